@@ -0,0 +1,204 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The IFA_F_* flags of an address
+const (
+	AddrFlagSecondary      = 0x01
+	AddrFlagNoDad          = 0x02
+	AddrFlagOptimistic     = 0x04
+	AddrFlagDadFailed      = 0x08
+	AddrFlagHomeAddress    = 0x10
+	AddrFlagDeprecated     = 0x20
+	AddrFlagTentative      = 0x40
+	AddrFlagPermanent      = 0x80
+	AddrFlagManageTempAddr = 0x100
+	AddrFlagNoPrefixRoute  = 0x200
+)
+
+// Address describes an ip address configured on a link
+type Address struct {
+	*net.IPNet
+	// Label must start with the name of the link, IPv4 only
+	Label string
+	// Broadcast is only used when adding IPv4 addresses, the kernel dump
+	// of the vendored netlink does not report it back
+	Broadcast net.IP
+	Scope     netlink.Scope
+	// Flags holds the AddrFlag* values
+	Flags int
+}
+
+func (a Address) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", a.IPNet, a.Label))
+}
+
+// equal compares the ip and the prefix length only, missing masks are defaulted
+func (a Address) equal(other Address) bool {
+	x, err := normalizeAddr(a.IPNet)
+	if err != nil {
+		return false
+	}
+	y, err := normalizeAddr(other.IPNet)
+	if err != nil {
+		return false
+	}
+	return x.Equal(*y)
+}
+
+// Addrs is used to list the addresses of the link, family is one of
+// netlink.FAMILY_ALL, netlink.FAMILY_V4 and netlink.FAMILY_V6
+func (lnk *linuxLink) Addrs(family int) ([]Address, error) {
+	var addrs []Address
+	err := lnk.exec(func() error {
+		nlAddrs, err := netlink.AddrList(lnk.link, family)
+		if err != nil {
+			return err
+		}
+		for _, nlAddr := range nlAddrs {
+			addrs = append(addrs, Address{IPNet: nlAddr.IPNet, Label: nlAddr.Label,
+				Scope: netlink.Scope(nlAddr.Scope), Flags: nlAddr.Flags})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the addresses of link %s due to %s",
+			lnk.link.Attrs().Name, err.Error())
+	}
+	return addrs, nil
+}
+
+// AddAddr is used to add an address to the link
+func (lnk *linuxLink) AddAddr(addr *Address) error {
+	return lnk.exec(func() error {
+		return modifyAddr(lnk.link, addr, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)
+	})
+}
+
+// DelAddr is used to delete an address from the link
+func (lnk *linuxLink) DelAddr(addr *Address) error {
+	if addr == nil || addr.IPNet == nil {
+		return fmt.Errorf("The address cannot be empty")
+	}
+	return lnk.exec(func() error {
+		err := netlink.AddrDel(lnk.link, &netlink.Addr{IPNet: addr.IPNet, Label: addr.Label})
+		if err != nil {
+			return fmt.Errorf("Failed to delete address %s due to %s", addr, err.Error())
+		}
+		return nil
+	})
+}
+
+// ReplaceAddrs is used to converge the addresses of the link to addrs. The
+// addresses missing from addrs are deleted, except the IPv6 link-local ones
+// which stay untouched. Present addresses are updated in place.
+func (lnk *linuxLink) ReplaceAddrs(addrs []*Address) error {
+	current, err := lnk.Addrs(netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	for _, cur := range current {
+		if cur.IP.To4() == nil && cur.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		wanted := false
+		for _, addr := range addrs {
+			if addr != nil && cur.equal(*addr) {
+				wanted = true
+				break
+			}
+		}
+		if !wanted {
+			err = lnk.DelAddr(&cur)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return lnk.exec(func() error {
+		for _, addr := range addrs {
+			err := modifyAddr(lnk.link, addr, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FlushAddrs is used to delete all the addresses of the given family
+func (lnk *linuxLink) FlushAddrs(family int) error {
+	addrs, err := lnk.Addrs(family)
+	if err != nil {
+		return err
+	}
+	for i := range addrs {
+		err = lnk.DelAddr(&addrs[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modifyAddr sends a RTM_NEWADDR request, the vendored AddrAdd cannot carry
+// a broadcast address nor replace an existing address. It must run in the
+// link's namespace.
+func modifyAddr(link netlink.Link, addr *Address, flags int) error {
+	if addr == nil || addr.IPNet == nil {
+		return fmt.Errorf("The address cannot be empty")
+	}
+	normalized, err := normalizeAddr(addr.IPNet)
+	if err != nil {
+		return err
+	}
+	base := link.Attrs()
+	if addr.Label != "" && !strings.HasPrefix(addr.Label, base.Name) {
+		return fmt.Errorf("The label %s must begin with the link name %s", addr.Label, base.Name)
+	}
+	ip := normalized.IP
+	family := nl.GetIPFamily(ip)
+	if family == netlink.FAMILY_V6 {
+		ip = ip.To16()
+	}
+	scope := addr.Scope
+	if scope == netlink.SCOPE_UNIVERSE {
+		scope = netlink.Scope(normalized.Scope)
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWADDR, flags|syscall.NLM_F_ACK)
+	msg := nl.NewIfAddrmsg(family)
+	msg.Index = uint32(base.Index)
+	msg.Scope = uint8(scope)
+	msg.Flags = uint8(addr.Flags & 0xff)
+	prefixLen, _ := normalized.Mask.Size()
+	msg.Prefixlen = uint8(prefixLen)
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(syscall.IFA_LOCAL, ip))
+	req.AddData(nl.NewRtAttr(syscall.IFA_ADDRESS, ip))
+	if addr.Flags != 0 {
+		req.AddData(nl.NewRtAttr(netlink.IFA_FLAGS, nl.Uint32Attr(uint32(addr.Flags))))
+	}
+	if addr.Broadcast != nil {
+		if family != netlink.FAMILY_V4 || addr.Broadcast.To4() == nil {
+			return fmt.Errorf("The broadcast address %s is only valid for IPv4", addr.Broadcast)
+		}
+		req.AddData(nl.NewRtAttr(syscall.IFA_BROADCAST, addr.Broadcast.To4()))
+	}
+	if addr.Label != "" {
+		req.AddData(nl.NewRtAttr(syscall.IFA_LABEL, nl.ZeroTerminated(addr.Label)))
+	}
+	_, err = req.Execute(syscall.NETLINK_ROUTE, 0)
+	if err != nil {
+		return fmt.Errorf("Failed to add address %s due to %s", normalized.IPNet, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// bondModes maps the vendored bond modes to the kernel values, the vendored
+// LinkAdd sends them unmapped and creates bonds of the wrong mode
+var bondModes = map[netlink.BondMode]uint8{
+	netlink.BOND_MODE_BALANCE_RR:    0,
+	netlink.BOND_MODE_ACTIVE_BACKUP: 1,
+	netlink.BOND_MODE_BALANCE_XOR:   2,
+	netlink.BOND_MODE_BROADCAST:     3,
+	netlink.BOND_MODE_802_3AD:       4,
+	netlink.BOND_MODE_BALANCE_TLB:   5,
+	netlink.BOND_MODE_BALANCE_ALB:   6,
+}
+
+// BondSlaveState is the state of a bond slave
+type BondSlaveState uint8
+
+// The states of a bond slave
+const (
+	BondSlaveActive BondSlaveState = iota
+	BondSlaveBackup
+)
+
+// BondMiiStatus is the link monitoring status of a bond slave
+type BondMiiStatus uint8
+
+// The link monitoring status of a bond slave
+const (
+	BondMiiUp BondMiiStatus = iota
+	BondMiiFail
+	BondMiiDown
+	BondMiiBack
+)
+
+// BondSlave describes a link enslaved to a bond
+type BondSlave struct {
+	Link             LinuxLink
+	State            BondSlaveState
+	MiiStatus        BondMiiStatus
+	LinkFailureCount int
+	PermHardwareAddr net.HardwareAddr
+	QueueID          int
+	// AggregatorID is the 802.3ad aggregator the slave belongs to
+	AggregatorID int
+}
+
+// Bond is the interface of a bond, it is a LinuxLink itself
+type Bond interface {
+	LinuxLink
+	Delete() error
+	Enslave(slave LinuxLink) error
+	Release(slave LinuxLink) error
+	SetActiveSlave(slave LinuxLink) error
+	ActiveSlave() (LinuxLink, error)
+	Slaves() ([]BondSlave, error)
+	AdInfo() (*netlink.BondAdInfo, error)
+}
+
+type bond struct {
+	*linuxLink
+}
+
+// NewBond is used to create a bond from the options of config, the options
+// left at -1 by netlink.NewLinkBond keep the kernel defaults
+func NewBond(config *netlink.Bond) (Bond, error) {
+	return newBond(nil, config)
+}
+
+// NewBond is used to create a bond inside the namespace
+func (ns *NetNS) NewBond(config *netlink.Bond) (Bond, error) {
+	return newBond(ns, config)
+}
+
+// BondByName is used to get an existing bond
+func BondByName(name string) (Bond, error) {
+	return bondByName(nil, name)
+}
+
+// BondByName is used to get an existing bond inside the namespace
+func (ns *NetNS) BondByName(name string) (Bond, error) {
+	return bondByName(ns, name)
+}
+
+func newBond(ns *NetNS, config *netlink.Bond) (Bond, error) {
+	if config == nil {
+		return nil, fmt.Errorf("The bond config cannot be nil")
+	}
+	mode, ok := bondModes[config.Mode]
+	if config.Mode >= 0 && !ok {
+		return nil, fmt.Errorf("The bond mode %s is not valid", config.Mode)
+	}
+	for _, ip := range config.ArpIpTargets {
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("The arp target %s is not an IPv4 address", ip)
+		}
+	}
+	u8Attrs := []struct {
+		attr  int
+		value int
+	}{
+		{nl.IFLA_BOND_USE_CARRIER, config.UseCarrier},
+		{nl.IFLA_BOND_PRIMARY_RESELECT, int(config.PrimaryReselect)},
+		{nl.IFLA_BOND_FAIL_OVER_MAC, int(config.FailOverMac)},
+		{nl.IFLA_BOND_XMIT_HASH_POLICY, int(config.XmitHashPolicy)},
+		{nl.IFLA_BOND_NUM_PEER_NOTIF, config.NumPeerNotif},
+		{nl.IFLA_BOND_ALL_SLAVES_ACTIVE, config.AllSlavesActive},
+		{nl.IFLA_BOND_AD_LACP_RATE, int(config.LacpRate)},
+		{nl.IFLA_BOND_AD_SELECT, int(config.AdSelect)},
+	}
+	u32Attrs := []struct {
+		attr  int
+		value int
+	}{
+		{nl.IFLA_BOND_MIIMON, config.Miimon},
+		{nl.IFLA_BOND_UPDELAY, config.UpDelay},
+		{nl.IFLA_BOND_DOWNDELAY, config.DownDelay},
+		{nl.IFLA_BOND_ARP_INTERVAL, config.ArpInterval},
+		{nl.IFLA_BOND_ARP_VALIDATE, int(config.ArpValidate)},
+		{nl.IFLA_BOND_ARP_ALL_TARGETS, int(config.ArpAllTargets)},
+		{nl.IFLA_BOND_RESEND_IGMP, config.ResendIgmp},
+		{nl.IFLA_BOND_MIN_LINKS, config.MinLinks},
+		{nl.IFLA_BOND_LP_INTERVAL, config.LpInterval},
+		{nl.IFLA_BOND_PACKETS_PER_SLAVE, config.PackersPerSlave},
+	}
+
+	lnk, err := addLinuxLinkInfo(ns, config.Name, "bond", 0, func(data *nl.RtAttr) {
+		if config.Mode >= 0 {
+			nl.NewRtAttrChild(data, nl.IFLA_BOND_MODE, nl.Uint8Attr(mode))
+		}
+		for _, a := range u8Attrs {
+			if a.value >= 0 {
+				nl.NewRtAttrChild(data, a.attr, nl.Uint8Attr(uint8(a.value)))
+			}
+		}
+		for _, a := range u32Attrs {
+			if a.value >= 0 {
+				nl.NewRtAttrChild(data, a.attr, nl.Uint32Attr(uint32(a.value)))
+			}
+		}
+		if len(config.ArpIpTargets) > 0 {
+			targets := nl.NewRtAttrChild(data, nl.IFLA_BOND_ARP_IP_TARGET, nil)
+			for i, ip := range config.ArpIpTargets {
+				nl.NewRtAttrChild(targets, i, []byte(ip.To4()))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bond{linuxLink: lnk}, nil
+}
+
+func bondByName(ns *NetNS, name string) (Bond, error) {
+	lnk, err := linuxLinkByName(ns, name)
+	if err != nil {
+		return nil, err
+	}
+	if lnk.link.Type() != "bond" {
+		return nil, fmt.Errorf("The link %s is a %s link instead of bond", name, lnk.link.Type())
+	}
+	return &bond{linuxLink: lnk}, nil
+}
+
+// Delete is used to delete the bond, its slaves are released
+func (bd *bond) Delete() error {
+	return bd.exec(func() error {
+		err := netlink.LinkDel(bd.link)
+		if err != nil {
+			return fmt.Errorf("Failed to delete bond %s due to %s",
+				bd.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// slave checks that the link lives in the namespace of the bond
+func (bd *bond) slave(slave LinuxLink) (*linuxLink, error) {
+	lnk, err := asLinuxLink(slave)
+	if err != nil {
+		return nil, err
+	}
+	same, err := sameNetNS(lnk.ns, bd.ns)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		return nil, fmt.Errorf("The link %s is not in the net ns of bond %s",
+			lnk.link.Attrs().Name, bd.link.Attrs().Name)
+	}
+	return lnk, nil
+}
+
+// Enslave is used to add the link to the bond, the link is set down while
+// it is enslaved and up afterwards. It does nothing when the link is already
+// a slave of the bond.
+func (bd *bond) Enslave(slave LinuxLink) error {
+	lnk, err := bd.slave(slave)
+	if err != nil {
+		return err
+	}
+	return bd.exec(func() error {
+		// The slave may have changed master since it was looked up
+		if err := lnk.load(); err != nil {
+			return err
+		}
+		if lnk.link.Attrs().MasterIndex == bd.link.Attrs().Index {
+			return nil
+		}
+		err := netlink.LinkSetDown(lnk.link)
+		if err == nil {
+			err = netlink.LinkSetMasterByIndex(lnk.link, bd.link.Attrs().Index)
+		}
+		if err == nil {
+			err = netlink.LinkSetUp(lnk.link)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to enslave link %s to bond %s due to %s",
+				lnk.link.Attrs().Name, bd.link.Attrs().Name, err.Error())
+		}
+		lnk.link.Attrs().MasterIndex = bd.link.Attrs().Index
+		return nil
+	})
+}
+
+// Release is used to release the link from the bond, it fails for a link
+// which is not a slave of the bond
+func (bd *bond) Release(slave LinuxLink) error {
+	lnk, err := bd.slave(slave)
+	if err != nil {
+		return err
+	}
+	return bd.exec(func() error {
+		// The slave may have changed master since it was looked up
+		if err := lnk.load(); err != nil {
+			return err
+		}
+		if lnk.link.Attrs().MasterIndex != bd.link.Attrs().Index {
+			return fmt.Errorf("The link %s is not a slave of bond %s",
+				lnk.link.Attrs().Name, bd.link.Attrs().Name)
+		}
+		err := netlink.LinkSetNoMaster(lnk.link)
+		if err != nil {
+			return fmt.Errorf("Failed to release link %s from bond %s due to %s",
+				lnk.link.Attrs().Name, bd.link.Attrs().Name, err.Error())
+		}
+		lnk.link.Attrs().MasterIndex = 0
+		return nil
+	})
+}
+
+// SetActiveSlave is used to change the active slave, it is only supported by
+// the active-backup, balance-tlb and balance-alb modes
+func (bd *bond) SetActiveSlave(slave LinuxLink) error {
+	lnk, err := bd.slave(slave)
+	if err != nil {
+		return err
+	}
+	return bd.exec(func() error {
+		err := setLinkInfoData(bd.link, func(data *nl.RtAttr) {
+			nl.NewRtAttrChild(data, nl.IFLA_BOND_ACTIVE_SLAVE,
+				nl.Uint32Attr(uint32(lnk.link.Attrs().Index)))
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to set active slave %s of bond %s due to %s",
+				lnk.link.Attrs().Name, bd.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// ActiveSlave is used to get the active slave, it returns nil when there is
+// none
+func (bd *bond) ActiveSlave() (LinuxLink, error) {
+	var active *linuxLink
+	err := bd.exec(func() error {
+		data, err := bd.infoData()
+		if err != nil {
+			return err
+		}
+		for _, attr := range data {
+			if attr.Attr.Type != nl.IFLA_BOND_ACTIVE_SLAVE {
+				continue
+			}
+			link, err := netlink.LinkByIndex(int(nl.NativeEndian().Uint32(attr.Value[0:4])))
+			if err != nil {
+				return fmt.Errorf("Failed to get active slave of bond %s due to %s",
+					bd.link.Attrs().Name, err.Error())
+			}
+			active = &linuxLink{link: link, ns: bd.ns}
+		}
+		return nil
+	})
+	if err != nil || active == nil {
+		return nil, err
+	}
+	return active, nil
+}
+
+// Slaves is used to list the slaves of the bond with their state
+func (bd *bond) Slaves() ([]BondSlave, error) {
+	var slaves []BondSlave
+	err := bd.exec(func() error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return fmt.Errorf("Failed to list links due to %s", err.Error())
+		}
+		for _, link := range links {
+			if link.Attrs().MasterIndex != bd.link.Attrs().Index {
+				continue
+			}
+			msgs, err := getLinkMsgs(link.Attrs().Index)
+			if err != nil || len(msgs) != 1 || msgs[0].slaveKind != "bond" {
+				continue
+			}
+			slave := BondSlave{Link: &linuxLink{link: link, ns: bd.ns}}
+			native := nl.NativeEndian()
+			for _, attr := range msgs[0].slaveData {
+				switch attr.Attr.Type {
+				case nl.IFLA_BOND_SLAVE_STATE:
+					slave.State = BondSlaveState(attr.Value[0])
+				case nl.IFLA_BOND_SLAVE_MII_STATUS:
+					slave.MiiStatus = BondMiiStatus(attr.Value[0])
+				case nl.IFLA_BOND_SLAVE_LINK_FAILURE_COUNT:
+					slave.LinkFailureCount = int(native.Uint32(attr.Value[0:4]))
+				case nl.IFLA_BOND_SLAVE_PERM_HWADDR:
+					slave.PermHardwareAddr = net.HardwareAddr(attr.Value)
+				case nl.IFLA_BOND_SLAVE_QUEUE_ID:
+					slave.QueueID = int(native.Uint16(attr.Value[0:2]))
+				case nl.IFLA_BOND_SLAVE_AD_AGGREGATOR_ID:
+					slave.AggregatorID = int(native.Uint16(attr.Value[0:2]))
+				}
+			}
+			slaves = append(slaves, slave)
+		}
+		return nil
+	})
+	return slaves, err
+}
+
+// AdInfo is used to get the active 802.3ad aggregator of the bond, it
+// returns nil when the bond is not in 802.3ad mode
+func (bd *bond) AdInfo() (*netlink.BondAdInfo, error) {
+	var info *netlink.BondAdInfo
+	err := bd.exec(func() error {
+		data, err := bd.infoData()
+		if err != nil {
+			return err
+		}
+		for _, attr := range data {
+			if attr.Attr.Type != nl.IFLA_BOND_AD_INFO {
+				continue
+			}
+			adAttrs, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return fmt.Errorf("Failed to parse 802.3ad info of bond %s due to %s",
+					bd.link.Attrs().Name, err.Error())
+			}
+			info = &netlink.BondAdInfo{}
+			native := nl.NativeEndian()
+			for _, adAttr := range adAttrs {
+				switch adAttr.Attr.Type {
+				case nl.IFLA_BOND_AD_INFO_AGGREGATOR:
+					info.AggregatorId = int(native.Uint16(adAttr.Value[0:2]))
+				case nl.IFLA_BOND_AD_INFO_NUM_PORTS:
+					info.NumPorts = int(native.Uint16(adAttr.Value[0:2]))
+				case nl.IFLA_BOND_AD_INFO_ACTOR_KEY:
+					info.ActorKey = int(native.Uint16(adAttr.Value[0:2]))
+				case nl.IFLA_BOND_AD_INFO_PARTNER_KEY:
+					info.PartnerKey = int(native.Uint16(adAttr.Value[0:2]))
+				case nl.IFLA_BOND_AD_INFO_PARTNER_MAC:
+					info.PartnerMac = net.HardwareAddr(adAttr.Value)
+				}
+			}
+		}
+		return nil
+	})
+	return info, err
+}
+
+// infoData gets the bond attributes, the vendored LinkByName drops them. It
+// must run in the bond's namespace.
+func (bd *bond) infoData() ([]syscall.NetlinkRouteAttr, error) {
+	msgs, err := getLinkMsgs(bd.link.Attrs().Index)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get bond %s due to %s", bd.link.Attrs().Name, err.Error())
+	}
+	if len(msgs) != 1 {
+		return nil, fmt.Errorf("Failed to get bond %s", bd.link.Attrs().Name)
+	}
+	return msgs[0].infoData, nil
+}
@@ -0,0 +1,250 @@
+package gonet
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// iflaBrStpState is IFLA_BR_STP_STATE of the bridge IFLA_INFO_DATA
+const iflaBrStpState = 5
+
+// BridgePortFlag is one of the per port flags of a bridge
+type BridgePortFlag int
+
+// The flags a bridge port supports
+const (
+	BridgePortHairpin BridgePortFlag = iota
+	BridgePortGuard
+	BridgePortFastLeave
+	BridgePortRootBlock
+	BridgePortLearning
+	BridgePortFlood
+)
+
+// Bridge is the interface of a linux bridge, it is a LinuxLink itself
+type Bridge interface {
+	LinuxLink
+	Delete() error
+	SetSTP(on bool) error
+	AddPort(port LinuxLink) error
+	RemovePort(port LinuxLink) error
+	Ports() ([]LinuxLink, error)
+	SetPortFlag(port LinuxLink, flag BridgePortFlag, on bool) error
+	PortFlags(port LinuxLink) (netlink.Protinfo, error)
+}
+
+type bridge struct {
+	*linuxLink
+}
+
+// NewBridge is used to create a bridge
+func NewBridge(name string) (Bridge, error) {
+	return newBridge(nil, name)
+}
+
+// NewBridge is used to create a bridge inside the namespace
+func (ns *NetNS) NewBridge(name string) (Bridge, error) {
+	return newBridge(ns, name)
+}
+
+func newBridge(ns *NetNS, name string) (Bridge, error) {
+	if name == "" {
+		return nil, fmt.Errorf("The bridge name cannot be empty")
+	}
+	lnk, err := addLinuxLink(ns, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}})
+	if err != nil {
+		return nil, err
+	}
+	return &bridge{linuxLink: lnk}, nil
+}
+
+// BridgeByName is used to get an existing bridge
+func BridgeByName(name string) (Bridge, error) {
+	return bridgeByName(nil, name)
+}
+
+// BridgeByName is used to get an existing bridge inside the namespace
+func (ns *NetNS) BridgeByName(name string) (Bridge, error) {
+	return bridgeByName(ns, name)
+}
+
+func bridgeByName(ns *NetNS, name string) (Bridge, error) {
+	lnk, err := linuxLinkByName(ns, name)
+	if err != nil {
+		return nil, err
+	}
+	if lnk.link.Type() != "bridge" {
+		return nil, fmt.Errorf("The link %s is a %s link instead of bridge", name, lnk.link.Type())
+	}
+	return &bridge{linuxLink: lnk}, nil
+}
+
+// Delete is used to delete the bridge, its ports are released
+func (br *bridge) Delete() error {
+	return br.exec(func() error {
+		err := netlink.LinkDel(br.link)
+		if err != nil {
+			return fmt.Errorf("Failed to delete bridge %s due to %s",
+				br.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// SetSTP is used to turn the spanning tree protocol on or off
+func (br *bridge) SetSTP(on bool) error {
+	state := uint32(0)
+	if on {
+		state = 1
+	}
+	return br.exec(func() error {
+		err := setLinkInfoData(br.link, func(data *nl.RtAttr) {
+			nl.NewRtAttrChild(data, iflaBrStpState, nl.Uint32Attr(state))
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to set stp of bridge %s due to %s",
+				br.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// port checks that the link lives in the namespace of the bridge
+func (br *bridge) port(port LinuxLink) (*linuxLink, error) {
+	lnk, err := asLinuxLink(port)
+	if err != nil {
+		return nil, err
+	}
+	same, err := sameNetNS(lnk.ns, br.ns)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		return nil, fmt.Errorf("The link %s is not in the net ns of bridge %s",
+			lnk.link.Attrs().Name, br.link.Attrs().Name)
+	}
+	return lnk, nil
+}
+
+// AddPort is used to enslave the link to the bridge, it does nothing when
+// the link is already a port of the bridge
+func (br *bridge) AddPort(port LinuxLink) error {
+	lnk, err := br.port(port)
+	if err != nil {
+		return err
+	}
+	return br.exec(func() error {
+		// The port may have changed master since it was looked up
+		if err := lnk.load(); err != nil {
+			return err
+		}
+		if lnk.link.Attrs().MasterIndex == br.link.Attrs().Index {
+			return nil
+		}
+		err := netlink.LinkSetMasterByIndex(lnk.link, br.link.Attrs().Index)
+		if err != nil {
+			return fmt.Errorf("Failed to add link %s to bridge %s due to %s",
+				lnk.link.Attrs().Name, br.link.Attrs().Name, err.Error())
+		}
+		lnk.link.Attrs().MasterIndex = br.link.Attrs().Index
+		return nil
+	})
+}
+
+// RemovePort is used to release the link from the bridge, it fails for a
+// link which is not a port of the bridge
+func (br *bridge) RemovePort(port LinuxLink) error {
+	lnk, err := br.port(port)
+	if err != nil {
+		return err
+	}
+	return br.exec(func() error {
+		// The port may have changed master since it was looked up
+		if err := lnk.load(); err != nil {
+			return err
+		}
+		if lnk.link.Attrs().MasterIndex != br.link.Attrs().Index {
+			return fmt.Errorf("The link %s is not a port of bridge %s",
+				lnk.link.Attrs().Name, br.link.Attrs().Name)
+		}
+		err := netlink.LinkSetNoMaster(lnk.link)
+		if err != nil {
+			return fmt.Errorf("Failed to remove link %s from bridge %s due to %s",
+				lnk.link.Attrs().Name, br.link.Attrs().Name, err.Error())
+		}
+		lnk.link.Attrs().MasterIndex = 0
+		return nil
+	})
+}
+
+// Ports is used to list the links enslaved to the bridge
+func (br *bridge) Ports() ([]LinuxLink, error) {
+	var ports []LinuxLink
+	err := br.exec(func() error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return fmt.Errorf("Failed to list links due to %s", err.Error())
+		}
+		for _, link := range links {
+			if link.Attrs().MasterIndex == br.link.Attrs().Index {
+				ports = append(ports, &linuxLink{link: link, ns: br.ns})
+			}
+		}
+		return nil
+	})
+	return ports, err
+}
+
+// SetPortFlag is used to turn one of the flags of a port on or off
+func (br *bridge) SetPortFlag(port LinuxLink, flag BridgePortFlag, on bool) error {
+	lnk, err := br.port(port)
+	if err != nil {
+		return err
+	}
+	var setFlag func(netlink.Link, bool) error
+	switch flag {
+	case BridgePortHairpin:
+		setFlag = netlink.LinkSetHairpin
+	case BridgePortGuard:
+		setFlag = netlink.LinkSetGuard
+	case BridgePortFastLeave:
+		setFlag = netlink.LinkSetFastLeave
+	case BridgePortRootBlock:
+		setFlag = netlink.LinkSetRootBlock
+	case BridgePortLearning:
+		setFlag = netlink.LinkSetLearning
+	case BridgePortFlood:
+		setFlag = netlink.LinkSetFlood
+	default:
+		return fmt.Errorf("The bridge port flag %d is not valid", flag)
+	}
+	return br.exec(func() error {
+		err := setFlag(lnk.link, on)
+		if err != nil {
+			return fmt.Errorf("Failed to set the flag of port %s due to %s",
+				lnk.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// PortFlags is used to read back the flags of a port
+func (br *bridge) PortFlags(port LinuxLink) (netlink.Protinfo, error) {
+	var info netlink.Protinfo
+	lnk, err := br.port(port)
+	if err != nil {
+		return info, err
+	}
+	err = br.exec(func() error {
+		var err error
+		info, err = netlink.LinkGetProtinfo(lnk.link)
+		if err != nil {
+			return fmt.Errorf("Failed to get the flags of port %s due to %s",
+				lnk.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+	return info, err
+}
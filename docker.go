@@ -2,20 +2,40 @@ package gonet
 
 import (
 	"fmt"
-	"net"
 
 	"github.com/vishvananda/netns"
 )
 
 // SetPeerLinkToDockerNs is used to put the link into containers namespace with specified
 // name
-func (lnk *linuxLink) SetToDockerNs(containerID, newName string, ip net.IP, mask net.IPMask) error {
+func (lnk *linuxLink) SetToDockerNs(containerID, newName string, config *IPConfig) error {
+	nsHandle, err := dockerNetNSHandle(containerID)
+	if err != nil {
+		return err
+	}
+	return lnk.putLinkIntoNetNS(lightNetNS(nsHandle), newName, config)
+}
+
+// dockerNetNSHandle opens the network namespace of a docker container from
+// its cgroup, or from the engine when the cgroup layout is not the one of
+// cgroup v1. A container the engine does not know or which is not running
+// is reported as gone.
+func dockerNetNSHandle(containerID string) (netns.NsHandle, error) {
 	if containerID == "" {
-		return fmt.Errorf("The container id cannot be empty")
+		return netns.None(), fmt.Errorf("The container id cannot be empty")
 	}
 	nsHandle, err := netns.GetFromDocker(containerID)
-	if err != nil {
-		return fmt.Errorf("Failed to get container's network namespace due to %s", err.Error())
+	if err == nil {
+		return nsHandle, nil
+	}
+	path, apiErr := ContainerNetNSPath("docker", containerID)
+	if apiErr != nil {
+		return nsHandle, &netNSError{fmt.Sprintf("Failed to get container's network namespace due to %s, %s",
+			err.Error(), apiErr.Error()), apiErr}
+	}
+	if nsHandle, err = netns.GetFromPath(path); err != nil {
+		return nsHandle, &netNSError{fmt.Sprintf("Failed to get container's network namespace due to %s",
+			err.Error()), err}
 	}
-	return lnk.putLinkIntoNetNS(nsHandle, newName, ip, mask)
+	return nsHandle, nil
 }
@@ -0,0 +1,255 @@
+package gonet
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// EndpointSpec describes the veth based endpoint connecting a namespace to
+// the host
+type EndpointSpec struct {
+	// HostName is the name of the veth end kept in the current namespace
+	HostName string
+	// ContainerName is the name of the veth end inside the target namespace
+	ContainerName string
+	// IPConfig is applied to the container end, its addresses replace the
+	// existing ones and its routes are added or replaced
+	IPConfig
+	// MTU is set on both ends, 0 keeps the kernel default
+	MTU int
+	// HardwareAddr is the mac address of the container end, nil keeps the
+	// generated one
+	HardwareAddr net.HardwareAddr
+	// Bridge is the name of the bridge the host end joins, empty for none
+	Bridge string
+	// Sysctls are written inside the target namespace, the keys are given
+	// below /proc/sys and must belong to the namespaced net tree, such as
+	// net/ipv4/conf/eth0/rp_filter or net.ipv4.ip_forward
+	Sysctls map[string]string
+}
+
+// Attach is used to converge the endpoint described by spec into the target
+// namespace. It creates whatever is missing and fixes whatever differs, so it
+// can be run again after a crash left the endpoint half configured. The
+// addresses missing from spec are removed, but the routes are only added or
+// replaced: a route dropped from spec is kept, since the container end may
+// hold routes the endpoint did not add.
+func Attach(target NetNSRef, spec *EndpointSpec) error {
+	if err := spec.validate(); err != nil {
+		return err
+	}
+	ns, owned, err := target.netNS()
+	if err != nil {
+		return err
+	}
+	if owned {
+		defer ns.Close()
+	}
+
+	host, peer, err := spec.ensureVeth(ns)
+	if err != nil {
+		return err
+	}
+	if err = spec.convergePeer(ns, peer); err != nil {
+		return err
+	}
+	return spec.convergeHost(host)
+}
+
+// Detach is used to remove the endpoint described by spec, removing an
+// endpoint which does not exist is not an error
+func Detach(target NetNSRef, spec *EndpointSpec) error {
+	if err := spec.validate(); err != nil {
+		return err
+	}
+	if link, err := netlink.LinkByName(spec.HostName); err == nil {
+		if link.Type() != "veth" {
+			return fmt.Errorf("The link %s is a %s link instead of veth", spec.HostName, link.Type())
+		}
+		return DeleteLink(spec.HostName)
+	}
+	ns, owned, err := target.netNS()
+	if err != nil {
+		// A net ns which is gone took its links with it
+		if isNetNSGone(err) {
+			return nil
+		}
+		return err
+	}
+	if owned {
+		defer ns.Close()
+	}
+	// The host end is gone, so is the container end unless it was renamed
+	// into an unrelated link, only remove a leftover veth
+	return ns.Do(func() error {
+		for _, name := range []string{spec.ContainerName, spec.tmpName()} {
+			link, err := netlink.LinkByName(name)
+			if err == nil && link.Type() == "veth" {
+				return netlink.LinkDel(link)
+			}
+		}
+		return nil
+	})
+}
+
+func (spec *EndpointSpec) validate() error {
+	if spec == nil {
+		return fmt.Errorf("The endpoint spec cannot be nil")
+	}
+	if spec.HostName == "" || spec.ContainerName == "" {
+		return fmt.Errorf("The names of the endpoint cannot be empty")
+	}
+	for key := range spec.Sysctls {
+		// A cleaned key cannot climb out of /proc/sys/net through ".."
+		p := sysctlPath(key)
+		if !strings.HasPrefix(p, "net/") || path.Clean(p) != p {
+			return fmt.Errorf("The sysctl %s is not a namespaced net sysctl", key)
+		}
+	}
+	return nil
+}
+
+// tmpName is the name of the container end before it is moved and renamed,
+// it is derived from the host name so that a crashed attach can be resumed
+func (spec *EndpointSpec) tmpName() string {
+	hash := fnv.New32a()
+	hash.Write([]byte(spec.HostName))
+	return fmt.Sprintf("gn%08x", hash.Sum32())
+}
+
+// ensureVeth finds or creates the veth pair and makes sure the container end
+// lives in ns under its final name. The returned peer is driven through ns.
+func (spec *EndpointSpec) ensureVeth(ns *NetNS) (*linuxLink, *linuxLink, error) {
+	tmpName := spec.tmpName()
+	host, err := linuxLinkByName(nil, spec.HostName)
+	if err != nil {
+		veth, err := newVethLinkPair(nil, spec.HostName, tmpName)
+		if err != nil {
+			return nil, nil, err
+		}
+		host = veth.IfcLink.(*linuxLink)
+	} else if host.link.Type() != "veth" {
+		return nil, nil, fmt.Errorf("The link %s is a %s link instead of veth",
+			spec.HostName, host.link.Type())
+	}
+
+	// The peer has not been moved yet
+	if peer, err := linuxLinkByName(nil, tmpName); err == nil {
+		err = peer.Down()
+		if err == nil {
+			err = peer.exec(func() error {
+				return netlink.LinkSetNsFd(peer.link, int(ns.Handle()))
+			})
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to move link %s into net ns due to %s",
+				tmpName, err.Error())
+		}
+	}
+
+	// The peer has been moved but not renamed yet
+	if peer, err := linuxLinkByName(ns, tmpName); err == nil {
+		err = peer.Down()
+		if err == nil {
+			err = peer.SetName(spec.ContainerName)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to rename link %s to %s due to %s",
+				tmpName, spec.ContainerName, err.Error())
+		}
+	}
+
+	peer, err := linuxLinkByName(ns, spec.ContainerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The peer ifindex may have changed while entering the net ns
+	if err = host.refresh(); err != nil {
+		return nil, nil, err
+	}
+	if peer.link.Type() != "veth" || host.link.Attrs().ParentIndex != peer.link.Attrs().Index {
+		return nil, nil, fmt.Errorf("The link %s in the net ns is not the peer of %s",
+			spec.ContainerName, spec.HostName)
+	}
+	return host, peer, nil
+}
+
+// convergePeer applies the spec to the container end inside ns
+func (spec *EndpointSpec) convergePeer(ns *NetNS, peer *linuxLink) error {
+	attrs := peer.link.Attrs()
+	if spec.MTU > 0 && attrs.MTU != spec.MTU {
+		if err := peer.SetMTU(spec.MTU); err != nil {
+			return err
+		}
+	}
+	if len(spec.HardwareAddr) > 0 && attrs.HardwareAddr.String() != spec.HardwareAddr.String() {
+		if err := peer.SetHardwareAddr(spec.HardwareAddr); err != nil {
+			return err
+		}
+	}
+	err := ns.Do(func() error {
+		for key, value := range spec.Sysctls {
+			if err := setSysctl(sysctlPath(key), value); err != nil {
+				return err
+			}
+		}
+		return applyIPv6Sysctls(spec.ContainerName, &spec.IPConfig)
+	})
+	if err != nil {
+		return err
+	}
+
+	var addrs []*Address
+	for _, ipNet := range spec.Addrs {
+		addr := &Address{IPNet: ipNet}
+		if spec.DisableDAD && ipNet != nil && ipNet.IP.To4() == nil {
+			addr.Flags |= AddrFlagNoDad
+		}
+		addrs = append(addrs, addr)
+	}
+	if err = peer.ReplaceAddrs(addrs); err != nil {
+		return err
+	}
+	if err = peer.Up(); err != nil {
+		return fmt.Errorf("Failed to set link %s up due to %s", spec.ContainerName, err.Error())
+	}
+	return peer.exec(func() error {
+		return applyRoutes(peer.link, spec.Routes)
+	})
+}
+
+// convergeHost applies the spec to the host end
+func (spec *EndpointSpec) convergeHost(host *linuxLink) error {
+	attrs := host.link.Attrs()
+	if spec.MTU > 0 && attrs.MTU != spec.MTU {
+		if err := host.SetMTU(spec.MTU); err != nil {
+			return err
+		}
+	}
+	if spec.Bridge != "" {
+		br, err := BridgeByName(spec.Bridge)
+		if err != nil {
+			return err
+		}
+		if err = br.AddPort(host); err != nil {
+			return err
+		}
+	}
+	if err := host.Up(); err != nil {
+		return fmt.Errorf("Failed to set link %s up due to %s", spec.HostName, err.Error())
+	}
+	return nil
+}
+
+// sysctlPath converts the dotted form of a sysctl key to its path
+func sysctlPath(key string) string {
+	if strings.Contains(key, "/") {
+		return strings.TrimPrefix(key, "/")
+	}
+	return strings.Replace(key, ".", "/", -1)
+}
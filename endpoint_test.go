@@ -0,0 +1,71 @@
+package gonet
+
+import "testing"
+
+func TestEndpointSpecValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   *EndpointSpec
+		sysctl string
+		valid  bool
+	}{
+		{name: "nil spec"},
+		{name: "no host name", spec: &EndpointSpec{ContainerName: "eth0"}},
+		{name: "no container name", spec: &EndpointSpec{HostName: "veth0"}},
+		{name: "no sysctl", valid: true},
+		{name: "path", sysctl: "net/ipv4/conf/eth0/rp_filter", valid: true},
+		{name: "leading slash", sysctl: "/net/ipv4/ip_forward", valid: true},
+		{name: "dotted", sysctl: "net.ipv6.conf.all.forwarding", valid: true},
+		{name: "not net", sysctl: "kernel/hostname"},
+		{name: "dotted not net", sysctl: "vm.swappiness"},
+		{name: "parent escape", sysctl: "net/../../../etc/foo"},
+		{name: "parent inside net", sysctl: "net/ipv4/../ipv6/conf/all/forwarding"},
+		{name: "current dir", sysctl: "net/./ipv4/ip_forward"},
+		{name: "double slash", sysctl: "net//ipv4/ip_forward"},
+	}
+	for _, test := range tests {
+		spec := test.spec
+		if spec == nil && test.name != "nil spec" {
+			spec = &EndpointSpec{HostName: "veth0", ContainerName: "eth0"}
+			if test.sysctl != "" {
+				spec.Sysctls = map[string]string{test.sysctl: "1"}
+			}
+		}
+		err := spec.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestDetachRemovedContainer(t *testing.T) {
+	socket := serveUnix(t, t.TempDir(), answerPaths(map[string]string{
+		"/containers/stopped/json": `{"State":{"Running":false,"Pid":0}}`,
+		"/containers/failing/json": "",
+	}))
+	resolversLock.RLock()
+	saved := resolvers["docker"]
+	resolversLock.RUnlock()
+	if err := RegisterNamespaceResolver("docker", &DockerResolver{Socket: socket}); err != nil {
+		t.Fatal(err)
+	}
+	defer RegisterNamespaceResolver("docker", saved)
+
+	spec := &EndpointSpec{HostName: "gntest-none0", ContainerName: "eth0"}
+	tests := []struct {
+		name   string
+		target NetNSRef
+		gone   bool
+	}{
+		{name: "removed container", target: DockerNetNS("gonet-test-removed"), gone: true},
+		{name: "stopped container", target: DockerNetNS("stopped"), gone: true},
+		{name: "failing engine", target: DockerNetNS("failing")},
+		{name: "empty container id", target: DockerNetNS("")},
+	}
+	for _, test := range tests {
+		err := Detach(test.target, spec)
+		if (err == nil) != test.gone {
+			t.Errorf("%s: got %v, want already detached %v", test.name, err, test.gone)
+		}
+	}
+}
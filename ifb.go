@@ -0,0 +1,179 @@
+package gonet
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// ingressRedirectPriority is the priority of the filter redirecting the
+// ingress traffic to an ifb
+const ingressRedirectPriority = 1
+
+// NewDummyLink is used to create a dummy link
+func NewDummyLink(name string) (LinuxLink, error) {
+	return newSimpleLink(nil, &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name, TxQLen: -1}})
+}
+
+// NewDummyLink is used to create a dummy link inside the namespace
+func (ns *NetNS) NewDummyLink(name string) (LinuxLink, error) {
+	return newSimpleLink(ns, &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name, TxQLen: -1}})
+}
+
+// NewIfbLink is used to create an ifb link
+func NewIfbLink(name string) (LinuxLink, error) {
+	return newSimpleLink(nil, &netlink.Ifb{LinkAttrs: netlink.LinkAttrs{Name: name, TxQLen: -1}})
+}
+
+// NewIfbLink is used to create an ifb link inside the namespace
+func (ns *NetNS) NewIfbLink(name string) (LinuxLink, error) {
+	return newSimpleLink(ns, &netlink.Ifb{LinkAttrs: netlink.LinkAttrs{Name: name, TxQLen: -1}})
+}
+
+func newSimpleLink(ns *NetNS, link netlink.Link) (LinuxLink, error) {
+	if link.Attrs().Name == "" {
+		return nil, fmt.Errorf("The %s link name cannot be empty", link.Type())
+	}
+	lnk, err := addLinuxLink(ns, link)
+	if err != nil {
+		return nil, err
+	}
+	return lnk, nil
+}
+
+// RedirectIngress is used to redirect the traffic received by link to the
+// ifb, where it can be shaped as egress traffic. An ingress qdisc is added
+// to link when missing and an existing redirect is replaced. Both links must
+// live in the same namespace.
+func RedirectIngress(link, ifb LinuxLink) error {
+	from, err := asLinuxLink(link)
+	if err != nil {
+		return err
+	}
+	to, err := asLinuxLink(ifb)
+	if err != nil {
+		return err
+	}
+	if to.link.Type() != "ifb" {
+		return fmt.Errorf("The link %s is a %s link instead of ifb", to.link.Attrs().Name, to.link.Type())
+	}
+	same, err := sameNetNS(from.ns, to.ns)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("The links %s and %s are not in the same net ns",
+			from.link.Attrs().Name, to.link.Attrs().Name)
+	}
+	if err = to.Up(); err != nil {
+		return fmt.Errorf("Failed to set link %s up due to %s", to.link.Attrs().Name, err.Error())
+	}
+	return from.exec(func() error {
+		if err := ensureIngressQdisc(from.link); err != nil {
+			return err
+		}
+		filter, err := ingressRedirect(from.link)
+		if err != nil {
+			return err
+		}
+		if filter != nil {
+			if filter.RedirIndex == to.link.Attrs().Index {
+				return nil
+			}
+			if err = netlink.FilterDel(filter); err != nil {
+				return fmt.Errorf("Failed to delete the ingress redirect of link %s due to %s",
+					from.link.Attrs().Name, err.Error())
+			}
+		}
+		// Match all the packets and steal them to the ifb
+		err = netlink.FilterAdd(&netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: from.link.Attrs().Index,
+				Parent:    netlink.MakeHandle(0xffff, 0),
+				Priority:  ingressRedirectPriority,
+				Protocol:  syscall.ETH_P_ALL,
+			},
+			Actions: []netlink.Action{netlink.NewMirredAction(to.link.Attrs().Index)},
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to redirect the ingress of link %s to %s due to %s",
+				from.link.Attrs().Name, to.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// ClearIngressRedirect is used to stop redirecting the traffic received by
+// link, the ingress qdisc is removed together with its filters
+func ClearIngressRedirect(link LinuxLink) error {
+	from, err := asLinuxLink(link)
+	if err != nil {
+		return err
+	}
+	return from.exec(func() error {
+		qdisc, err := ingressQdisc(from.link)
+		if err != nil || qdisc == nil {
+			return err
+		}
+		if err = netlink.QdiscDel(qdisc); err != nil {
+			return fmt.Errorf("Failed to delete the ingress qdisc of link %s due to %s",
+				from.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// ingressQdisc returns the ingress qdisc of the link or nil, it must run in
+// the link's namespace
+func ingressQdisc(link netlink.Link) (netlink.Qdisc, error) {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list qdiscs of link %s due to %s",
+			link.Attrs().Name, err.Error())
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent == netlink.HANDLE_INGRESS {
+			return qdisc, nil
+		}
+	}
+	return nil, nil
+}
+
+// ensureIngressQdisc adds the ingress qdisc unless it exists, it must run in
+// the link's namespace
+func ensureIngressQdisc(link netlink.Link) error {
+	qdisc, err := ingressQdisc(link)
+	if err != nil || qdisc != nil {
+		return err
+	}
+	err = netlink.QdiscAdd(&netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to add the ingress qdisc of link %s due to %s",
+			link.Attrs().Name, err.Error())
+	}
+	return nil
+}
+
+// ingressRedirect returns the redirect filter added by RedirectIngress or
+// nil, it must run in the link's namespace
+func ingressRedirect(link netlink.Link) (*netlink.U32, error) {
+	filters, err := netlink.FilterList(link, netlink.MakeHandle(0xffff, 0))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list ingress filters of link %s due to %s",
+			link.Attrs().Name, err.Error())
+	}
+	for _, filter := range filters {
+		u32, ok := filter.(*netlink.U32)
+		if ok && u32.Priority == ingressRedirectPriority && u32.RedirIndex != 0 {
+			return u32, nil
+		}
+	}
+	return nil, nil
+}
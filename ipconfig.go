@@ -0,0 +1,131 @@
+package gonet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// DefaultIPv6PrefixLen is used for IPv6 addresses given without a mask
+const DefaultIPv6PrefixLen = 64
+
+// IPConfig describes the ip configuration applied to a link
+type IPConfig struct {
+	// Addrs holds the addresses of either family. An address without a
+	// mask gets the default mask of its class for IPv4 or a /64 for IPv6.
+	Addrs []*net.IPNet
+	// DisableDAD turns off IPv6 duplicate address detection on the link
+	DisableDAD bool
+	// DisableSLAAC turns off IPv6 router advertisements and autoconfiguration
+	DisableSLAAC bool
+	// NoLinkLocal stops the kernel from generating an IPv6 link-local
+	// address, link-local addresses may still be given in Addrs
+	NoLinkLocal bool
+	// Routes are added through the link once it is up, a route without
+	// destination is a default route
+	Routes []*Route
+}
+
+// normalizeAddr fills in the default mask and scope of the address
+func normalizeAddr(ipNet *net.IPNet) (*netlink.Addr, error) {
+	if ipNet == nil || ipNet.IP == nil {
+		return nil, fmt.Errorf("Failed to configure the IP since the ip is not valid")
+	}
+	ip := ipNet.IP
+	mask := ipNet.Mask
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if mask == nil {
+			mask = ip.DefaultMask()
+		}
+		if mask == nil {
+			mask = net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)
+		}
+	} else if mask == nil {
+		mask = net.CIDRMask(DefaultIPv6PrefixLen, 8*net.IPv6len)
+	}
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: mask}}
+	if ip.IsLinkLocalUnicast() {
+		addr.Scope = int(netlink.SCOPE_LINK)
+	}
+	return addr, nil
+}
+
+// addAddrs adds the addresses to the link, it must run in the link's namespace
+func addAddrs(link netlink.Link, addrs []*net.IPNet, noDad bool) error {
+	for _, ipNet := range addrs {
+		addr := &Address{IPNet: ipNet}
+		if noDad && ipNet != nil && ipNet.IP.To4() == nil {
+			addr.Flags |= AddrFlagNoDad
+		}
+		err := modifyAddr(link, addr, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyIPConfig configures the link, it must run in the link's namespace
+// after the link got its final name
+func applyIPConfig(link netlink.Link, config *IPConfig) error {
+	if config == nil {
+		return nil
+	}
+	err := applyIPv6Sysctls(link.Attrs().Name, config)
+	if err != nil {
+		return err
+	}
+	return addAddrs(link, config.Addrs, config.DisableDAD)
+}
+
+// applyRoutes adds or replaces the routes going out through the link, it
+// must run in the link's namespace once the link is up
+func applyRoutes(link netlink.Link, routes []*Route) error {
+	for _, route := range routes {
+		if route == nil {
+			continue
+		}
+		err := execRouteRequest(route, link.Attrs().Index, syscall.RTM_NEWROUTE,
+			syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE)
+		if err != nil {
+			return fmt.Errorf("Failed to add route %s due to %s", route, err.Error())
+		}
+	}
+	return nil
+}
+
+// applyIPv6Sysctls sets the per link IPv6 knobs. The /proc/sys/net tree
+// reflects the namespace of the calling thread.
+func applyIPv6Sysctls(name string, config *IPConfig) error {
+	var sysctls [][2]string
+	if config.DisableDAD {
+		sysctls = append(sysctls, [2]string{"accept_dad", "0"})
+	}
+	if config.DisableSLAAC {
+		sysctls = append(sysctls, [2]string{"accept_ra", "0"}, [2]string{"autoconf", "0"})
+	}
+	if config.NoLinkLocal {
+		sysctls = append(sysctls, [2]string{"addr_gen_mode", "1"})
+	}
+	for _, sysctl := range sysctls {
+		err := setSysctl(filepath.Join("net/ipv6/conf", name, sysctl[0]), sysctl[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSysctl writes value to the sysctl key, given as a path below /proc/sys
+func setSysctl(key, value string) error {
+	err := ioutil.WriteFile(filepath.Join("/proc/sys", key), []byte(value), 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to set sysctl %s to %s due to %s", key, value, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package gonet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestNormalizeAddr(t *testing.T) {
+	tests := []struct {
+		ipNet *net.IPNet
+		want  string
+		scope netlink.Scope
+	}{
+		{ipNet: &net.IPNet{IP: net.ParseIP("10.1.2.3")}, want: "10.1.2.3/8"},
+		{ipNet: &net.IPNet{IP: net.ParseIP("172.16.0.1")}, want: "172.16.0.1/16"},
+		{ipNet: &net.IPNet{IP: net.ParseIP("192.168.1.1")}, want: "192.168.1.1/24"},
+		{ipNet: &net.IPNet{IP: net.ParseIP("224.0.0.1")}, want: "224.0.0.1/24"},
+		{ipNet: &net.IPNet{IP: net.ParseIP("10.1.2.3"), Mask: net.CIDRMask(30, 32)},
+			want: "10.1.2.3/30"},
+		{ipNet: &net.IPNet{IP: net.ParseIP("169.254.1.1")}, want: "169.254.1.1/16",
+			scope: netlink.SCOPE_LINK},
+		{ipNet: &net.IPNet{IP: net.ParseIP("2001:db8::1")}, want: "2001:db8::1/64"},
+		{ipNet: &net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(128, 128)},
+			want: "2001:db8::1/128"},
+		{ipNet: &net.IPNet{IP: net.ParseIP("fe80::1")}, want: "fe80::1/64",
+			scope: netlink.SCOPE_LINK},
+	}
+	for _, test := range tests {
+		addr, err := normalizeAddr(test.ipNet)
+		if err != nil {
+			t.Errorf("normalizeAddr(%s): %v", test.ipNet.IP, err)
+			continue
+		}
+		if addr.IPNet.String() != test.want || netlink.Scope(addr.Scope) != test.scope {
+			t.Errorf("normalizeAddr(%s) = %s scope %d, want %s scope %d", test.ipNet.IP,
+				addr.IPNet, addr.Scope, test.want, test.scope)
+		}
+		if test.ipNet.IP.To4() != nil && len(addr.IP) != net.IPv4len {
+			t.Errorf("normalizeAddr(%s) kept a %d bytes address", test.ipNet.IP, len(addr.IP))
+		}
+	}
+	for _, ipNet := range []*net.IPNet{nil, {}} {
+		if _, err := normalizeAddr(ipNet); err == nil {
+			t.Errorf("normalizeAddr(%v) must fail", ipNet)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// IPVlanMode is the mode of an ipvlan link
+type IPVlanMode uint16
+
+// The supported ipvlan modes
+const (
+	IPVlanModeL2 IPVlanMode = iota
+	IPVlanModeL3
+	IPVlanModeL3S
+)
+
+// NewIPVlanLink is used to create an ipvlan link on top of parent, the link
+// is created in the namespace of parent
+func NewIPVlanLink(parent LinuxLink, name string, mode IPVlanMode) (LinuxLink, error) {
+	lnk, err := newIPVlanLink(parent, name, mode)
+	if err != nil {
+		return nil, err
+	}
+	return lnk, nil
+}
+
+// NewIPVlanEndpoint is used to create an ipvlan link on top of parent and
+// put it into the target namespace, such as PidNetNS or DockerNetNS, as
+// newName with the given config. In L3 and L3S modes the neighbours are
+// unreachable through the parent, so a default device route is added for
+// every family of config.Addrs which has no default route in config.Routes.
+// The link is deleted when any step fails.
+func NewIPVlanEndpoint(parent LinuxLink, name string, mode IPVlanMode,
+	target NetNSRef, newName string, config *IPConfig) (LinuxLink, error) {
+	if target == nil {
+		return nil, fmt.Errorf("The target net ns cannot be nil")
+	}
+	if newName == "" {
+		return nil, fmt.Errorf("The new name cannot be empty")
+	}
+	ns, owned, err := target.netNS()
+	if err != nil {
+		return nil, err
+	}
+	if owned {
+		defer ns.Close()
+	}
+	// The link is driven through moved once it is in the target namespace
+	moved, err := keepNetNS(ns, owned)
+	if err != nil {
+		return nil, err
+	}
+	lnk, err := newIPVlanLink(parent, name, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = lnk.exec(func() error {
+		return netlink.LinkSetNsFd(lnk.link, int(ns.Handle()))
+	})
+	if err != nil {
+		lnk.exec(func() error { return netlink.LinkDel(lnk.link) })
+		return nil, fmt.Errorf("Failed to move link %s into net ns due to %s", name, err.Error())
+	}
+
+	lnk.ns = moved
+	if err = configureIPVlan(lnk, mode, newName, config); err != nil {
+		lnk.exec(func() error {
+			link, lookupErr := netlink.LinkByName(lnk.link.Attrs().Name)
+			if lookupErr != nil {
+				return lookupErr
+			}
+			return netlink.LinkDel(link)
+		})
+		return nil, err
+	}
+	return lnk, nil
+}
+
+func newIPVlanLink(parent LinuxLink, name string, mode IPVlanMode) (*linuxLink, error) {
+	parentLink, err := asLinuxLink(parent)
+	if err != nil {
+		return nil, err
+	}
+	if mode > IPVlanModeL3S {
+		return nil, fmt.Errorf("The ipvlan mode %d is not valid", mode)
+	}
+	return addLinuxLinkInfo(parentLink.ns, name, "ipvlan", parentLink.link.Attrs().Index,
+		func(data *nl.RtAttr) {
+			nl.NewRtAttrChild(data, nl.IFLA_IPVLAN_MODE, nl.Uint16Attr(uint16(mode)))
+		})
+}
+
+// configureIPVlan renames, addresses and routes the ipvlan link once it is
+// inside its namespace
+func configureIPVlan(lnk *linuxLink, mode IPVlanMode, newName string, config *IPConfig) error {
+	// The ifindex may change when the link enters the new namespace
+	if err := lnk.refresh(); err != nil {
+		return fmt.Errorf("Failed to find link %s in net ns due to %s",
+			lnk.link.Attrs().Name, err.Error())
+	}
+	if newName != lnk.link.Attrs().Name {
+		if err := lnk.SetName(newName); err != nil {
+			return fmt.Errorf("Failed to set the link to new name %s due to %s",
+				newName, err.Error())
+		}
+	}
+	if config == nil {
+		return lnk.Up()
+	}
+	err := lnk.exec(func() error {
+		return applyIPConfig(lnk.link, config)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to configure the links ip due to %s", err.Error())
+	}
+	if err = lnk.Up(); err != nil {
+		return err
+	}
+	routes := config.Routes
+	if mode != IPVlanModeL2 {
+		routes = append(ipvlanDeviceRoutes(config), routes...)
+	}
+	err = lnk.exec(func() error {
+		return applyRoutes(lnk.link, routes)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to configure the links routes due to %s", err.Error())
+	}
+	return nil
+}
+
+// ipvlanDeviceRoutes returns the default device routes missing from config
+func ipvlanDeviceRoutes(config *IPConfig) []*Route {
+	hasDefault := make(map[int]bool)
+	for _, route := range config.Routes {
+		if route == nil {
+			continue
+		}
+		if route.Dst == nil || route.Dst.IP == nil {
+			hasDefault[routeFamily(route)] = true
+		} else if ones, _ := route.Dst.Mask.Size(); ones == 0 {
+			hasDefault[nl.GetIPFamily(route.Dst.IP)] = true
+		}
+	}
+	var routes []*Route
+	for _, ipNet := range config.Addrs {
+		if ipNet == nil || ipNet.IP == nil {
+			continue
+		}
+		family := nl.GetIPFamily(ipNet.IP)
+		if hasDefault[family] {
+			continue
+		}
+		hasDefault[family] = true
+		dst := &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 8*net.IPv4len)}
+		if family == netlink.FAMILY_V6 {
+			dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8*net.IPv6len)}
+		}
+		routes = append(routes, &Route{Dst: dst})
+	}
+	return routes
+}
+
+// routeFamily returns the family of a route without destination
+func routeFamily(route *Route) int {
+	switch {
+	case route.Gw != nil:
+		return nl.GetIPFamily(route.Gw)
+	case route.Src != nil:
+		return nl.GetIPFamily(route.Src)
+	case len(route.MultiPath) > 0 && route.MultiPath[0] != nil && route.MultiPath[0].Gw != nil:
+		return nl.GetIPFamily(route.MultiPath[0].Gw)
+	}
+	return netlink.FAMILY_V4
+}
@@ -0,0 +1,76 @@
+package gonet
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestIPVlanDeviceRoutes(t *testing.T) {
+	cidr := func(s string) *net.IPNet {
+		ip, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipNet.IP = ip
+		return ipNet
+	}
+	v4Default := "0.0.0.0/0"
+	v6Default := "::/0"
+	tests := []struct {
+		name   string
+		config IPConfig
+		want   []string
+	}{
+		{
+			name:   "both families",
+			config: IPConfig{Addrs: []*net.IPNet{cidr("10.0.0.2/24"), cidr("2001:db8::2/64")}},
+			want:   []string{v4Default, v6Default},
+		},
+		{
+			name:   "one route per family",
+			config: IPConfig{Addrs: []*net.IPNet{cidr("10.0.0.2/24"), cidr("10.0.1.2/24")}},
+			want:   []string{v4Default},
+		},
+		{
+			name: "default gateway",
+			config: IPConfig{
+				Addrs:  []*net.IPNet{cidr("10.0.0.2/24"), cidr("2001:db8::2/64")},
+				Routes: []*Route{{Gw: net.ParseIP("10.0.0.1")}},
+			},
+			want: []string{v6Default},
+		},
+		{
+			name: "default destination",
+			config: IPConfig{
+				Addrs:  []*net.IPNet{cidr("2001:db8::2/64")},
+				Routes: []*Route{nil, {Dst: cidr("::/0"), Gw: net.ParseIP("2001:db8::1")}},
+			},
+		},
+		{
+			name: "other destination",
+			config: IPConfig{
+				Addrs:  []*net.IPNet{cidr("10.0.0.2/24")},
+				Routes: []*Route{{Dst: cidr("192.168.0.0/16"), Gw: net.ParseIP("10.0.0.1")}},
+			},
+			want: []string{v4Default},
+		},
+		{
+			name:   "no address",
+			config: IPConfig{Addrs: []*net.IPNet{nil, {}}},
+		},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, route := range ipvlanDeviceRoutes(&test.config) {
+			if route.Gw != nil || route.Dst == nil {
+				t.Errorf("%s: the route %s is not a device route", test.name, route)
+				continue
+			}
+			got = append(got, route.Dst.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
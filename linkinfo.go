@@ -0,0 +1,231 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// iflaLinkNetnsID is the IFLA attribute holding the namespace id of the
+// parent or peer of a link, the vendored nl package does not define it
+const iflaLinkNetnsID = 37
+
+// LinkOperState is the RFC 2863 operational state of a link
+type LinkOperState uint8
+
+// The IF_OPER_* states
+const (
+	OperUnknown LinkOperState = iota
+	OperNotPresent
+	OperDown
+	OperLowerLayerDown
+	OperTesting
+	OperDormant
+	OperUp
+)
+
+var operStateNames = []string{"unknown", "notpresent", "down", "lowerlayerdown",
+	"testing", "dormant", "up"}
+
+func (s LinkOperState) String() string {
+	if int(s) < len(operStateNames) {
+		return operStateNames[s]
+	}
+	return fmt.Sprintf("operstate-%d", uint8(s))
+}
+
+// LinkFilter selects the links ListLinks returns, the empty filter selects
+// all of them
+type LinkFilter struct {
+	// Names are shell patterns the link names are matched against
+	Names []string
+	// Types are the link types wanted, such as veth or bridge
+	Types []string
+	// MasterIndex keeps the links enslaved to the link with that index
+	MasterIndex int
+}
+
+// ListLinks is used to list the links of the current namespace
+func ListLinks(filter *LinkFilter) ([]LinuxLink, error) {
+	return listLinks(nil, filter)
+}
+
+// ListLinks is used to list the links inside the namespace
+func (ns *NetNS) ListLinks(filter *LinkFilter) ([]LinuxLink, error) {
+	return listLinks(ns, filter)
+}
+
+func listLinks(ns *NetNS, filter *LinkFilter) ([]LinuxLink, error) {
+	if filter == nil {
+		filter = &LinkFilter{}
+	}
+	for _, pattern := range filter.Names {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("The link name pattern %s is not valid", pattern)
+		}
+	}
+	var msgs []*linkMsg
+	err := ns.run(func() error {
+		var err error
+		msgs, err = getLinkMsgs(0)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list links due to %s", err.Error())
+	}
+	// Both the link and its state come from the same dump
+	var links []LinuxLink
+	for _, msg := range msgs {
+		if link := msg.link(); filter.match(link) {
+			links = append(links, &linuxLink{link: link, ns: ns, msg: msg})
+		}
+	}
+	return links, nil
+}
+
+func (filter *LinkFilter) match(link netlink.Link) bool {
+	attrs := link.Attrs()
+	if filter.MasterIndex != 0 && attrs.MasterIndex != filter.MasterIndex {
+		return false
+	}
+	if len(filter.Types) > 0 {
+		found := false
+		for _, t := range filter.Types {
+			if t == link.Type() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(filter.Names) == 0 {
+		return true
+	}
+	for _, pattern := range filter.Names {
+		if ok, _ := filepath.Match(pattern, attrs.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh is used to reload the state of the link, the accessors describe
+// the link as of its lookup or its last refresh. Refresh is the way to learn
+// that the state cannot be read, the accessors have no error to report.
+func (lnk *linuxLink) Refresh() error {
+	return lnk.exec(func() error {
+		index := lnk.link.Attrs().Index
+		link, err := netlink.LinkByIndex(index)
+		if err != nil {
+			return fmt.Errorf("Failed to refresh link %s due to %s", lnk.link.Attrs().Name, err.Error())
+		}
+		msgs, err := getLinkMsgs(index)
+		if err != nil {
+			return fmt.Errorf("Failed to refresh link %s due to %s", lnk.link.Attrs().Name, err.Error())
+		}
+		if len(msgs) == 0 {
+			return fmt.Errorf("Failed to refresh link %s", lnk.link.Attrs().Name)
+		}
+		lnk.link, lnk.msg = link, msgs[0]
+		return nil
+	})
+}
+
+// state returns the link message of the last refresh, loading it on first
+// use. When the link cannot be read, such as once it has been deleted, the
+// message is empty and Flags, OperState and NetNSID report 0, OperUnknown
+// and -1 until a Refresh succeeds.
+func (lnk *linuxLink) state() *linkMsg {
+	if lnk.msg == nil {
+		if err := lnk.Refresh(); err != nil {
+			return &linkMsg{index: lnk.link.Attrs().Index}
+		}
+	}
+	return lnk.msg
+}
+
+// Name returns the name of the link
+func (lnk *linuxLink) Name() string {
+	return lnk.link.Attrs().Name
+}
+
+// Index returns the ifindex of the link inside its namespace
+func (lnk *linuxLink) Index() int {
+	return lnk.link.Attrs().Index
+}
+
+// Type returns the kind of the link, such as veth, bridge or device
+func (lnk *linuxLink) Type() string {
+	return lnk.link.Type()
+}
+
+// MTU returns the mtu of the link
+func (lnk *linuxLink) MTU() int {
+	return lnk.link.Attrs().MTU
+}
+
+// MAC returns the hardware address of the link
+func (lnk *linuxLink) MAC() net.HardwareAddr {
+	return lnk.link.Attrs().HardwareAddr
+}
+
+// Alias returns the alias of the link, empty when it has none
+func (lnk *linuxLink) Alias() string {
+	return lnk.link.Attrs().Alias
+}
+
+// MasterIndex returns the ifindex of the bridge or bond the link is enslaved
+// to, 0 when it has no master
+func (lnk *linuxLink) MasterIndex() int {
+	return lnk.link.Attrs().MasterIndex
+}
+
+// ParentIndex returns the ifindex of the link the vlan, macvlan or ipvlan
+// link sits on, 0 for the links without parent. The index belongs to the
+// namespace given by NetNSID.
+func (lnk *linuxLink) ParentIndex() int {
+	if lnk.link.Type() == "veth" {
+		return 0
+	}
+	return lnk.link.Attrs().ParentIndex
+}
+
+// PeerIndex returns the ifindex of the other end of a veth link, 0 for the
+// other links. The index belongs to the namespace given by NetNSID.
+func (lnk *linuxLink) PeerIndex() int {
+	if lnk.link.Type() != "veth" {
+		return 0
+	}
+	return lnk.link.Attrs().ParentIndex
+}
+
+// Flags returns the IFF_* flags of the link, syscall.IFF_UP among them, 0
+// when the state of the link cannot be read
+func (lnk *linuxLink) Flags() uint32 {
+	return lnk.state().flags
+}
+
+// OperState returns the operational state of the link, OperUnknown when the
+// state of the link cannot be read
+func (lnk *linuxLink) OperState() LinkOperState {
+	if value := lnk.state().attr(syscall.IFLA_OPERSTATE); len(value) >= 1 {
+		return LinkOperState(value[0])
+	}
+	return OperUnknown
+}
+
+// NetNSID returns the id the namespace of the link gives to the namespace
+// the parent or the peer of the link lives in, -1 when it is the same one or
+// when the state of the link cannot be read
+func (lnk *linuxLink) NetNSID() int {
+	if value := lnk.state().attr(iflaLinkNetnsID); len(value) >= 4 {
+		return int(int32(nl.NativeEndian().Uint32(value[0:4])))
+	}
+	return -1
+}
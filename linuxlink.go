@@ -1,39 +1,118 @@
 package gonet
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"syscall"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 	"github.com/vishvananda/netns"
 )
 
 // LinuxLink is the main interface towards the outside
 // It describes the API of the link
 type LinuxLink interface {
+	Name() string
+	Index() int
+	Type() string
+	MTU() int
+	MAC() net.HardwareAddr
+	Alias() string
+	MasterIndex() int
+	ParentIndex() int
+	PeerIndex() int
+	Flags() uint32
+	OperState() LinkOperState
+	NetNSID() int
+	Refresh() error
 	Up() error
 	Down() error
 	SetName(name string) error
-	Ifconfig(ip net.IP, netmask net.IPMask) error
-	SetToNetNs(nspid int, newName string, ip net.IP, mask net.IPMask) error
-	SetToDockerNs(containerID, newName string, ip net.IP, mask net.IPMask) error
+	SetMTU(mtu int) error
+	SetHardwareAddr(hwaddr net.HardwareAddr) error
+	Ifconfig(addrs ...*net.IPNet) error
+	Addrs(family int) ([]Address, error)
+	AddAddr(addr *Address) error
+	DelAddr(addr *Address) error
+	ReplaceAddrs(addrs []*Address) error
+	FlushAddrs(family int) error
+	AddRoute(route *Route) error
+	DelRoute(route *Route) error
+	Routes(family int) ([]Route, error)
+	SetDefaultGateway(gw net.IP) error
+	SetEgressRate(rate, burst string) error
+	ClearEgressRate() error
+	ApplyNetem(profile NetemProfile) error
+	ClearNetem() error
+	AddNeighbor(neigh *Neighbor) error
+	SetNeighbor(neigh *Neighbor) error
+	DelNeighbor(neigh *Neighbor) error
+	Neighbors(family int) ([]Neighbor, error)
+	FlushNeighbors(family, state int) error
+	AddFDBEntry(entry *FDBEntry) error
+	DelFDBEntry(entry *FDBEntry) error
+	FDBEntries() ([]FDBEntry, error)
+	SetToNetNs(nspid int, newName string, config *IPConfig) error
+	SetToDockerNs(containerID, newName string, config *IPConfig) error
+	SetToNs(target NetNSRef, newName string, config *IPConfig) error
+	SetToContainerNs(runtime, id, newName string, config *IPConfig) error
 }
 
 // LinuxLink ...
 type linuxLink struct {
 	link netlink.Link
+	// ns is the namespace the link lives in, nil for the caller's namespace
+	ns *NetNS
+	// msg holds the link state the vendored netlink does not keep, nil
+	// until it is loaded
+	msg *linkMsg
 	//ifc  *net.Interface
 }
 
+// exec runs fn in the namespace the link lives in
+func (lnk *linuxLink) exec(fn func() error) error {
+	return lnk.ns.run(fn)
+}
+
+// refresh reloads the link attributes by name from the link's namespace
+func (lnk *linuxLink) refresh() error {
+	return lnk.exec(func() error {
+		link, err := netlink.LinkByName(lnk.link.Attrs().Name)
+		if err != nil {
+			return err
+		}
+		lnk.link, lnk.msg = link, nil
+		return nil
+	})
+}
+
+// load reloads the link attributes by index, it must run in the link's
+// namespace
+func (lnk *linuxLink) load() error {
+	link, err := netlink.LinkByIndex(lnk.link.Attrs().Index)
+	if err != nil {
+		return fmt.Errorf("Failed to find link %s due to %s", lnk.link.Attrs().Name, err.Error())
+	}
+	lnk.link, lnk.msg = link, nil
+	return nil
+}
+
 // Up is used to set the link to up state
 func (lnk *linuxLink) Up() error {
-	return netlink.LinkSetUp(lnk.link)
+	lnk.msg = nil
+	return lnk.exec(func() error {
+		return netlink.LinkSetUp(lnk.link)
+	})
 }
 
 // Down is used to set the link to up state
 func (lnk *linuxLink) Down() error {
-	return netlink.LinkSetDown(lnk.link)
+	lnk.msg = nil
+	return lnk.exec(func() error {
+		return netlink.LinkSetDown(lnk.link)
+	})
 }
 
 // SetName is used to set the link to up state
@@ -41,47 +120,341 @@ func (lnk *linuxLink) SetName(name string) error {
 	if name == "" {
 		return fmt.Errorf("The link name cannot be empty")
 	}
-	return netlink.LinkSetName(lnk.link, name)
+	err := lnk.exec(func() error {
+		return netlink.LinkSetName(lnk.link, name)
+	})
+	if err != nil {
+		return err
+	}
+	lnk.link.Attrs().Name = name
+	return nil
 }
 
-// Ifconfig is used to configure the basic ip of the link
-func (lnk *linuxLink) Ifconfig(ip net.IP, netmask net.IPMask) error {
-	if ip == nil {
-		return fmt.Errorf("Failed to configure the IP since the ip is not valid")
+// SetMTU is used to set the mtu of the link
+func (lnk *linuxLink) SetMTU(mtu int) error {
+	if mtu <= 0 {
+		return fmt.Errorf("The mtu %d is not valid", mtu)
 	}
-	if netmask == nil {
-		netmask = ip.DefaultMask()
+	err := lnk.exec(func() error {
+		return netlink.LinkSetMTU(lnk.link, mtu)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to set the mtu of link %s to %d due to %s",
+			lnk.link.Attrs().Name, mtu, err.Error())
 	}
-	ipNet := &net.IPNet{IP: ip, Mask: netmask}
-	netAddr := &netlink.Addr{IPNet: ipNet}
-	return netlink.AddrAdd(lnk.link, netAddr)
+	lnk.link.Attrs().MTU = mtu
+	return nil
+}
+
+// SetHardwareAddr is used to set the mac address of the link
+func (lnk *linuxLink) SetHardwareAddr(hwaddr net.HardwareAddr) error {
+	if len(hwaddr) == 0 {
+		return fmt.Errorf("The hardware address cannot be empty")
+	}
+	err := lnk.exec(func() error {
+		return netlink.LinkSetHardwareAddr(lnk.link, hwaddr)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to set the hardware address of link %s to %s due to %s",
+			lnk.link.Attrs().Name, hwaddr, err.Error())
+	}
+	lnk.link.Attrs().HardwareAddr = hwaddr
+	return nil
+}
+
+// Ifconfig is used to configure the ips of the link, the addresses may be of
+// either family and get a default mask when it is missing
+func (lnk *linuxLink) Ifconfig(addrs ...*net.IPNet) error {
+	if len(addrs) == 0 {
+		return fmt.Errorf("The addresses to configure cannot be empty")
+	}
+	return lnk.exec(func() error {
+		return addAddrs(lnk.link, addrs, false)
+	})
 }
 
 // LinuxLinkByName is used to get the link object
 func LinuxLinkByName(name string) (LinuxLink, error) {
-	link, err := netlink.LinkByName(name)
+	lnk, err := linuxLinkByName(nil, name)
+	if err != nil {
+		return nil, err
+	}
+	return lnk, nil
+}
+
+// LinuxLinkByName is used to get the link object inside the namespace
+func (ns *NetNS) LinuxLinkByName(name string) (LinuxLink, error) {
+	lnk, err := linuxLinkByName(ns, name)
+	if err != nil {
+		return nil, err
+	}
+	return lnk, nil
+}
+
+func linuxLinkByName(ns *NetNS, name string) (*linuxLink, error) {
+	var link netlink.Link
+	err := ns.run(func() error {
+		var err error
+		link, err = netlink.LinkByName(name)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("Failed to retrieve link via name %s due to %s",
 			name, err.Error())
 
 	}
-	return &linuxLink{ /*ifc: ifc, */ link: link}, err
+	return &linuxLink{ /*ifc: ifc, */ link: link, ns: ns}, err
+}
+
+// addLinuxLink creates the link inside ns and looks it up, the link is
+// removed again if it cannot be found
+func addLinuxLink(ns *NetNS, link netlink.Link) (*linuxLink, error) {
+	name := link.Attrs().Name
+	err := ns.run(func() error {
+		return netlink.LinkAdd(link)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s link %s due to %s",
+			link.Type(), name, err.Error())
+	}
+	lnk, err := linuxLinkByName(ns, name)
+	if err != nil {
+		deleteLink(ns, name)
+		return nil, err
+	}
+	return lnk, nil
+}
+
+// addLinuxLinkInfo creates a link of the given kind inside ns with a raw
+// RTM_NEWLINK request, for the link data the vendored LinkAdd cannot encode.
+// fillData adds the IFLA_INFO_DATA attributes, parentIndex 0 means no parent.
+func addLinuxLinkInfo(ns *NetNS, name, kind string, parentIndex int,
+	fillData func(data *nl.RtAttr)) (*linuxLink, error) {
+	if name == "" {
+		return nil, fmt.Errorf("The link name cannot be empty")
+	}
+	err := ns.run(func() error {
+		req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK,
+			syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+		req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+		if parentIndex != 0 {
+			req.AddData(nl.NewRtAttr(syscall.IFLA_LINK, nl.Uint32Attr(uint32(parentIndex))))
+		}
+		req.AddData(nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(name)))
+		linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+		nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated(kind))
+		if fillData != nil {
+			fillData(nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil))
+		}
+		req.AddData(linkInfo)
+		_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s link %s due to %s", kind, name, err.Error())
+	}
+	lnk, err := linuxLinkByName(ns, name)
+	if err != nil {
+		deleteLink(ns, name)
+		return nil, err
+	}
+	return lnk, nil
+}
+
+// setLinkInfoData changes the IFLA_INFO_DATA attributes of an existing
+// link, it must run in the link's namespace
+func setLinkInfoData(link netlink.Link, fillData func(data *nl.RtAttr)) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated(link.Type()))
+	fillData(nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil))
+	req.AddData(linkInfo)
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// The IFLA_LINKINFO attributes describing the master of a link, the vendored
+// nl package does not define them
+const (
+	iflaInfoSlaveKind = 4
+	iflaInfoSlaveData = 5
+)
+
+// linkMsg is a link message as sent by the kernel, with the attributes the
+// vendored netlink does not keep
+type linkMsg struct {
+	index     int
+	flags     uint32
+	kind      string
+	attrs     []syscall.NetlinkRouteAttr
+	infoData  []syscall.NetlinkRouteAttr
+	slaveKind string
+	slaveData []syscall.NetlinkRouteAttr
+}
+
+// getLinkMsgs gets the message of the link with the index, or of all the
+// links when index is 0. It must run inside the namespace.
+func getLinkMsgs(index int) ([]*linkMsg, error) {
+	var req *nl.NetlinkRequest
+	if index == 0 {
+		req = nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_DUMP)
+	} else {
+		req = nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	}
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+	raws, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*linkMsg
+	for _, raw := range raws {
+		lm, err := parseLinkMsg(raw)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, lm)
+	}
+	return msgs, nil
+}
+
+// parseLinkMsg decodes a RTM_NEWLINK or RTM_DELLINK message
+func parseLinkMsg(raw []byte) (*linkMsg, error) {
+	if len(raw) < syscall.SizeofIfInfomsg {
+		return nil, fmt.Errorf("The link message is too short")
+	}
+	ifInfo := nl.DeserializeIfInfomsg(raw)
+	attrs, err := nl.ParseRouteAttr(raw[ifInfo.Len():])
+	if err != nil {
+		return nil, err
+	}
+	lm := &linkMsg{index: int(ifInfo.Index), flags: ifInfo.Flags, attrs: attrs}
+	for _, attr := range attrs {
+		if attr.Attr.Type != syscall.IFLA_LINKINFO {
+			continue
+		}
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			switch info.Attr.Type {
+			case nl.IFLA_INFO_KIND:
+				lm.kind = string(bytes.TrimRight(info.Value, "\x00"))
+			case nl.IFLA_INFO_DATA:
+				lm.infoData, _ = nl.ParseRouteAttr(info.Value)
+			case iflaInfoSlaveKind:
+				lm.slaveKind = string(bytes.TrimRight(info.Value, "\x00"))
+			case iflaInfoSlaveData:
+				lm.slaveData, _ = nl.ParseRouteAttr(info.Value)
+			}
+		}
+	}
+	return lm, nil
+}
+
+// attr returns the value of the attribute of the link, nil when missing
+func (lm *linkMsg) attr(kind uint16) []byte {
+	for _, attr := range lm.attrs {
+		if attr.Attr.Type == kind {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+// name returns the name of the link
+func (lm *linkMsg) name() string {
+	value := lm.attr(syscall.IFLA_IFNAME)
+	return string(value[:clen(value)])
+}
+
+// link returns the link described by the message with the attributes the
+// vendored netlink decodes for every kind, which is all the link operations
+// need. The links without kind are devices like for the vendored netlink.
+func (lm *linkMsg) link() netlink.Link {
+	attrs := netlink.LinkAttrs{Index: lm.index, Name: lm.name()}
+	for _, flag := range []struct {
+		raw  uint32
+		flag net.Flags
+	}{
+		{syscall.IFF_UP, net.FlagUp},
+		{syscall.IFF_BROADCAST, net.FlagBroadcast},
+		{syscall.IFF_LOOPBACK, net.FlagLoopback},
+		{syscall.IFF_POINTOPOINT, net.FlagPointToPoint},
+		{syscall.IFF_MULTICAST, net.FlagMulticast},
+	} {
+		if lm.flags&flag.raw != 0 {
+			attrs.Flags |= flag.flag
+		}
+	}
+	if value := lm.attr(syscall.IFLA_ADDRESS); len(bytes.Trim(value, "\x00")) > 0 {
+		attrs.HardwareAddr = net.HardwareAddr(value)
+	}
+	if value := lm.attr(syscall.IFLA_IFALIAS); value != nil {
+		attrs.Alias = string(value[:clen(value)])
+	}
+	for kind, field := range map[uint16]*int{
+		syscall.IFLA_MTU:    &attrs.MTU,
+		syscall.IFLA_LINK:   &attrs.ParentIndex,
+		syscall.IFLA_MASTER: &attrs.MasterIndex,
+		syscall.IFLA_TXQLEN: &attrs.TxQLen,
+	} {
+		if value := lm.attr(kind); len(value) >= 4 {
+			*field = int(nl.NativeEndian().Uint32(value[0:4]))
+		}
+	}
+	kind := lm.kind
+	if kind == "" {
+		kind = "device"
+	}
+	return &netlink.GenericLink{LinkAttrs: attrs, LinkType: kind}
+}
+
+// linux gives access to the link behind the types embedding a linuxLink
+func (lnk *linuxLink) linux() *linuxLink {
+	return lnk
+}
+
+// asLinuxLink returns the implementation behind a LinuxLink
+func asLinuxLink(link LinuxLink) (*linuxLink, error) {
+	if l, ok := link.(interface {
+		linux() *linuxLink
+	}); ok && l.linux() != nil {
+		return l.linux(), nil
+	}
+	return nil, fmt.Errorf("The link is not a valid gonet link")
 }
 
 // DeleteLink is used to delete the link object
 func DeleteLink(name string) error {
+	return deleteLink(nil, name)
+}
+
+// DeleteLink is used to delete the link object inside the namespace
+func (ns *NetNS) DeleteLink(name string) error {
+	return deleteLink(ns, name)
+}
+
+func deleteLink(ns *NetNS, name string) error {
 	if name == "" {
 		return fmt.Errorf("The name of the link is not valid")
 	}
-	netLnk, err := netlink.LinkByName(name)
-	if err != nil {
-		return fmt.Errorf("Failed to find the link with name %s due to %s", name, err.Error())
-	}
-	return netlink.LinkDel(netLnk)
+	return ns.run(func() error {
+		netLnk, err := netlink.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("Failed to find the link with name %s due to %s", name, err.Error())
+		}
+		return netlink.LinkDel(netLnk)
+	})
 }
 
 // SetToNetNs is used to put a network interface into netns
-func (lnk *linuxLink) SetToNetNs(nspid int, newName string, ip net.IP, mask net.IPMask) error {
+func (lnk *linuxLink) SetToNetNs(nspid int, newName string, config *IPConfig) error {
 	if newName == "" {
 		return fmt.Errorf("The new name cannot be empty")
 	}
@@ -90,27 +463,28 @@ func (lnk *linuxLink) SetToNetNs(nspid int, newName string, ip net.IP, mask net.
 	if err != nil {
 		return fmt.Errorf("Failed to get the net ns for pid %d due to %s", nspid, err.Error())
 	}
-
-	return lnk.putLinkIntoNetNS(newNsHandle, newName, ip, mask)
+	return lnk.putLinkIntoNetNS(lightNetNS(newNsHandle), newName, config)
 }
 
-func (lnk *linuxLink) putLinkIntoNetNS(nsHandle netns.NsHandle, newName string, ip net.IP, mask net.IPMask) error {
+// putLinkIntoNetNS moves the link into target, the link is driven through
+// target afterwards so target must outlive the link
+func (lnk *linuxLink) putLinkIntoNetNS(target *NetNS, newName string, config *IPConfig) error {
 	err := lnk.Down()
 	if err != nil {
 		return fmt.Errorf("Failed to put link down due to %s", err.Error())
 	}
-	currentNetNs, err := netns.Get()
+	err = lnk.exec(func() error {
+		return netlink.LinkSetNsFd(lnk.link, int(target.handle))
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to get current net ns due to %s", err.Error())
+		return fmt.Errorf("Failed to set net ns %d due to %s", target.handle, err.Error())
 	}
-	defer netns.Setns(currentNetNs, syscall.CLONE_NEWNET)
-	err = netlink.LinkSetNsFd(lnk.link, int(nsHandle))
+	lnk.ns = target
+	// The ifindex may change when the link enters the new namespace
+	err = lnk.refresh()
 	if err != nil {
-		return fmt.Errorf("Failed to set net ns %d due to %s", nsHandle, err.Error())
-	}
-	err = netns.Set(nsHandle)
-	if err != nil {
-		return fmt.Errorf("Failed to switch to set net ns %d due to %s", nsHandle, err.Error())
+		return fmt.Errorf("Failed to find link %s in net ns %d due to %s",
+			lnk.link.Attrs().Name, target.handle, err.Error())
 	}
 	if newName != lnk.link.Attrs().Name {
 		err = lnk.SetName(newName)
@@ -120,11 +494,25 @@ func (lnk *linuxLink) putLinkIntoNetNS(nsHandle netns.NsHandle, newName string,
 		}
 	}
 
-	if ip != nil {
-		err = lnk.Ifconfig(ip, mask)
+	if config != nil {
+		err = lnk.exec(func() error {
+			return applyIPConfig(lnk.link, config)
+		})
 		if err != nil {
 			return fmt.Errorf("Failed to configure the links ip due to %s", err.Error())
 		}
 	}
-	return lnk.Up()
+	err = lnk.Up()
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		err = lnk.exec(func() error {
+			return applyRoutes(lnk.link, config.Routes)
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to configure the links routes due to %s", err.Error())
+		}
+	}
+	return nil
 }
@@ -0,0 +1,77 @@
+package gonet
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// MacvlanMode is the mode of a macvlan or macvtap link
+type MacvlanMode uint32
+
+// The supported macvlan modes, MacvlanModeDefault leaves the choice to the
+// kernel which uses vepa
+const (
+	MacvlanModeDefault  MacvlanMode = 0
+	MacvlanModePrivate  MacvlanMode = nl.MACVLAN_MODE_PRIVATE
+	MacvlanModeVepa     MacvlanMode = nl.MACVLAN_MODE_VEPA
+	MacvlanModeBridge   MacvlanMode = nl.MACVLAN_MODE_BRIDGE
+	MacvlanModePassthru MacvlanMode = nl.MACVLAN_MODE_PASSTHRU
+)
+
+// MacvtapLink is the interface of a macvtap link, it is a LinuxLink itself
+type MacvtapLink interface {
+	LinuxLink
+	TapPath() string
+}
+
+type macvtapLink struct {
+	*linuxLink
+}
+
+// NewMacvlanLink is used to create a macvlan link on top of parent, the link
+// is created in the namespace of parent
+func NewMacvlanLink(parent LinuxLink, name string, mode MacvlanMode) (LinuxLink, error) {
+	lnk, err := newMacvlanLink(parent, name, "macvlan", mode)
+	if err != nil {
+		return nil, err
+	}
+	return lnk, nil
+}
+
+// NewMacvtapLink is used to create a macvtap link on top of parent, the link
+// is created in the namespace of parent
+func NewMacvtapLink(parent LinuxLink, name string, mode MacvlanMode) (MacvtapLink, error) {
+	lnk, err := newMacvlanLink(parent, name, "macvtap", mode)
+	if err != nil {
+		return nil, err
+	}
+	return &macvtapLink{linuxLink: lnk}, nil
+}
+
+// newMacvlanLink creates a link of the macvlan family. The vendored LinkAdd
+// drops the mode of macvtap links, so the request is built here.
+func newMacvlanLink(parent LinuxLink, name, kind string, mode MacvlanMode) (*linuxLink, error) {
+	parentLink, err := asLinuxLink(parent)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case MacvlanModeDefault, MacvlanModePrivate, MacvlanModeVepa, MacvlanModeBridge, MacvlanModePassthru:
+	default:
+		return nil, fmt.Errorf("The %s mode %d is not valid", kind, mode)
+	}
+	return addLinuxLinkInfo(parentLink.ns, name, kind, parentLink.link.Attrs().Index,
+		func(data *nl.RtAttr) {
+			if mode != MacvlanModeDefault {
+				nl.NewRtAttrChild(data, nl.IFLA_MACVLAN_MODE, nl.Uint32Attr(uint32(mode)))
+			}
+		})
+}
+
+// TapPath is used to get the character device of the macvtap link. The
+// device is named after the ifindex, which changes when the link enters
+// another namespace, and is only created by udev in the host namespace.
+func (tap *macvtapLink) TapPath() string {
+	return fmt.Sprintf("/dev/tap%d", tap.link.Attrs().Index)
+}
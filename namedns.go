@@ -0,0 +1,181 @@
+package gonet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netns"
+)
+
+// NamedNetNSDir is the directory the named network namespaces are bind
+// mounted in, it is shared with `ip netns`
+const NamedNetNSDir = "/var/run/netns"
+
+// NewNamedNetNS is used to create a network namespace bind mounted under
+// NamedNetNSDir, like `ip netns add` does. The namespace outlives the
+// returned NetNS until DeleteNamedNetNS is called.
+func NewNamedNetNS(name string) (*NetNS, error) {
+	path, err := namedNetNSPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = ensureNamedNetNSDir(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create net ns file %s due to %s", path, err.Error())
+	}
+	file.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		errCh <- bindNewNetNS(path)
+	}()
+	if err = <-errCh; err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	ns, err := NetNSFromPath(path)
+	if err != nil {
+		DeleteNamedNetNS(name)
+		return nil, err
+	}
+	return ns, nil
+}
+
+// bindNewNetNS must be called on a locked OS thread, it moves the thread to
+// a new namespace, bind mounts it at path and moves the thread back. Like
+// runInNetNS it only unlocks the thread once it is back.
+func bindNewNetNS(path string) error {
+	origNs, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Failed to get current net ns due to %s", err.Error())
+	}
+	defer origNs.Close()
+
+	if err = syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Failed to create net ns due to %s", err.Error())
+	}
+	source := fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid())
+	err = syscall.Mount(source, path, "none", syscall.MS_BIND, "")
+	if err != nil {
+		err = fmt.Errorf("Failed to bind net ns to %s due to %s", path, err.Error())
+	}
+	if restoreErr := netns.Set(origNs); restoreErr != nil {
+		if err == nil {
+			err = fmt.Errorf("Failed to restore the original net ns due to %s", restoreErr.Error())
+		}
+		return err
+	}
+	runtime.UnlockOSThread()
+	return err
+}
+
+// ensureNamedNetNSDir creates NamedNetNSDir as a shared mount point, so that
+// the namespaces mounted later show up in the other mount namespaces too
+func ensureNamedNetNSDir() error {
+	if err := os.MkdirAll(NamedNetNSDir, 0755); err != nil {
+		return fmt.Errorf("Failed to create %s due to %s", NamedNetNSDir, err.Error())
+	}
+	err := syscall.Mount("", NamedNetNSDir, "none", syscall.MS_SHARED|syscall.MS_REC, "")
+	if err != syscall.EINVAL {
+		if err != nil {
+			return fmt.Errorf("Failed to share %s due to %s", NamedNetNSDir, err.Error())
+		}
+		return nil
+	}
+	// The directory is not a mount point yet, bind it onto itself first
+	err = syscall.Mount(NamedNetNSDir, NamedNetNSDir, "none", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("Failed to bind %s due to %s", NamedNetNSDir, err.Error())
+	}
+	err = syscall.Mount("", NamedNetNSDir, "none", syscall.MS_SHARED|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("Failed to share %s due to %s", NamedNetNSDir, err.Error())
+	}
+	return nil
+}
+
+// DeleteNamedNetNS is used to delete a named network namespace, like
+// `ip netns delete` does. The namespace itself lives on as long as a process
+// or an open NetNS still refers to it.
+func DeleteNamedNetNS(name string) error {
+	path, err := namedNetNSPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(path); err != nil {
+		return fmt.Errorf("Failed to find net ns %s due to %s", name, err.Error())
+	}
+	err = syscall.Unmount(path, syscall.MNT_DETACH)
+	if err != nil && err != syscall.EINVAL {
+		return fmt.Errorf("Failed to unmount net ns %s due to %s", name, err.Error())
+	}
+	if err = os.Remove(path); err != nil {
+		return fmt.Errorf("Failed to remove net ns file %s due to %s", path, err.Error())
+	}
+	return nil
+}
+
+// ListNamedNetNS is used to list the names of the named network namespaces
+func ListNamedNetNS() ([]string, error) {
+	infos, err := ioutil.ReadDir(NamedNetNSDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s due to %s", NamedNetNSDir, err.Error())
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// namedNetNSPath returns the path of the named namespace, the name must not
+// escape NamedNetNSDir
+func namedNetNSPath(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("The net ns name cannot be empty")
+	}
+	if name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return "", fmt.Errorf("The net ns name %s is not valid", name)
+	}
+	return filepath.Join(NamedNetNSDir, name), nil
+}
+
+// SetToNs is used to put the link into the target namespace with the new
+// name
+func (lnk *linuxLink) SetToNs(target NetNSRef, newName string, config *IPConfig) error {
+	if target == nil {
+		return fmt.Errorf("The target net ns cannot be nil")
+	}
+	if newName == "" {
+		return fmt.Errorf("The new name cannot be empty")
+	}
+	ns, owned, err := target.netNS()
+	if err != nil {
+		return err
+	}
+	if owned {
+		defer ns.Close()
+	}
+	// A NetNS of the caller keeps driving the link, an opened one is closed
+	moved, err := keepNetNS(ns, owned)
+	if err != nil {
+		return err
+	}
+	return lnk.putLinkIntoNetNS(moved, newName, config)
+}
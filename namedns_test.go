@@ -0,0 +1,45 @@
+package gonet
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNamedNetNSPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "blue", path: filepath.Join(NamedNetNSDir, "blue")},
+		{name: "cni-1234", path: filepath.Join(NamedNetNSDir, "cni-1234")},
+		{name: "..blue", path: filepath.Join(NamedNetNSDir, "..blue")},
+		{name: ""},
+		{name: "."},
+		{name: ".."},
+		{name: "a/b"},
+		{name: "../etc"},
+	}
+	for _, test := range tests {
+		path, err := namedNetNSPath(test.name)
+		if test.path == "" {
+			if err == nil {
+				t.Errorf("namedNetNSPath(%q) = %q, want an error", test.name, path)
+			}
+			continue
+		}
+		if err != nil || path != test.path {
+			t.Errorf("namedNetNSPath(%q) = %q, %v, want %q", test.name, path, err, test.path)
+		}
+	}
+}
+
+func TestNetNSFromNameRejectsPaths(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../../proc/1/ns/net", "a/b", "/proc/1/ns/net"} {
+		if ns, err := NetNSFromName(name); err == nil || isNetNSGone(err) {
+			if ns != nil {
+				ns.Close()
+			}
+			t.Errorf("NetNSFromName(%q) = %v, want an invalid name error", name, err)
+		}
+	}
+}
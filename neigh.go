@@ -0,0 +1,306 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The NUD_* states of a neighbor
+const (
+	NeighStateIncomplete = netlink.NUD_INCOMPLETE
+	NeighStateReachable  = netlink.NUD_REACHABLE
+	NeighStateStale      = netlink.NUD_STALE
+	NeighStateDelay      = netlink.NUD_DELAY
+	NeighStateProbe      = netlink.NUD_PROBE
+	NeighStateFailed     = netlink.NUD_FAILED
+	NeighStateNoARP      = netlink.NUD_NOARP
+	NeighStatePermanent  = netlink.NUD_PERMANENT
+)
+
+// The NTF_* flags telling which fdb an entry belongs to
+const (
+	// FDBFlagSelf is the fdb of the link itself, such as a bridge or vxlan
+	FDBFlagSelf = netlink.NTF_SELF
+	// FDBFlagMaster is the fdb of the bridge the link is a port of
+	FDBFlagMaster = netlink.NTF_MASTER
+)
+
+// Neighbor describes an entry of the ARP or NDP table of a link
+type Neighbor struct {
+	IP  net.IP
+	MAC net.HardwareAddr
+	// State holds the NeighState* values, 0 adds a permanent entry
+	State int
+	// Proxy makes the link answer the ARP and NDP requests for IP, a proxy
+	// entry has no MAC
+	Proxy bool
+	// Router flags an IPv6 neighbor as a router
+	Router bool
+}
+
+func (n Neighbor) String() string {
+	if n.Proxy {
+		return fmt.Sprintf("%s proxy", n.IP)
+	}
+	return fmt.Sprintf("%s %s", n.IP, n.MAC)
+}
+
+// FDBEntry describes an entry of a bridge forwarding database
+type FDBEntry struct {
+	MAC net.HardwareAddr
+	// VLAN is the vlan id of the entry, 0 for none
+	VLAN int
+	// Dst is the remote the entry points at on the tunnel links like vxlan
+	Dst net.IP
+	// Local marks the addresses delivered to the host, other entries are
+	// static forwarding entries
+	Local bool
+	// Flags holds the FDBFlag* values, 0 defaults to FDBFlagSelf like the
+	// bridge tool does
+	Flags int
+}
+
+func (e FDBEntry) String() string {
+	return fmt.Sprintf("%s vlan %d", e.MAC, e.VLAN)
+}
+
+// neighEntry is a neighbor message of the dump
+type neighEntry struct {
+	netlink.Neigh
+	vlan int
+}
+
+// AddNeighbor is used to add a neighbor entry to the link, it fails when the
+// entry exists
+func (lnk *linuxLink) AddNeighbor(neigh *Neighbor) error {
+	return lnk.modifyNeighbor(neigh, syscall.RTM_NEWNEIGH, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)
+}
+
+// SetNeighbor is used to add a neighbor entry to the link or to replace the
+// existing one
+func (lnk *linuxLink) SetNeighbor(neigh *Neighbor) error {
+	return lnk.modifyNeighbor(neigh, syscall.RTM_NEWNEIGH, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE)
+}
+
+// DelNeighbor is used to delete a neighbor entry of the link
+func (lnk *linuxLink) DelNeighbor(neigh *Neighbor) error {
+	return lnk.modifyNeighbor(neigh, syscall.RTM_DELNEIGH, 0)
+}
+
+func (lnk *linuxLink) modifyNeighbor(neigh *Neighbor, cmd, flags int) error {
+	if neigh == nil || neigh.IP == nil {
+		return fmt.Errorf("The ip of the neighbor cannot be empty")
+	}
+	if cmd == syscall.RTM_NEWNEIGH && !neigh.Proxy && len(neigh.MAC) == 0 {
+		return fmt.Errorf("The mac of the neighbor %s cannot be empty", neigh.IP)
+	}
+	msg := &netlink.Ndmsg{
+		Family: uint8(nl.GetIPFamily(neigh.IP)),
+		Index:  uint32(lnk.link.Attrs().Index),
+		State:  uint16(neigh.State),
+	}
+	mac := neigh.MAC
+	if neigh.Proxy {
+		msg.Flags, msg.State, mac = netlink.NTF_PROXY, 0, nil
+	} else if msg.State == 0 {
+		msg.State = netlink.NUD_PERMANENT
+	}
+	if neigh.Router {
+		msg.Flags |= netlink.NTF_ROUTER
+	}
+	return lnk.exec(func() error {
+		// The kernel only answers NDP for the proxy entries once asked to
+		if neigh.Proxy && cmd == syscall.RTM_NEWNEIGH && neigh.IP.To4() == nil {
+			err := setSysctl(filepath.Join("net/ipv6/conf", lnk.link.Attrs().Name, "proxy_ndp"), "1")
+			if err != nil {
+				return err
+			}
+		}
+		err := neighRequest(cmd, flags, msg, neigh.IP, mac, 0)
+		if err != nil {
+			return fmt.Errorf("Failed to modify neighbor %s of link %s due to %s",
+				neigh, lnk.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// Neighbors is used to list the neighbor entries of the link, the proxy ones
+// included, family is one of netlink.FAMILY_ALL, netlink.FAMILY_V4 and
+// netlink.FAMILY_V6
+func (lnk *linuxLink) Neighbors(family int) ([]Neighbor, error) {
+	var neighs []Neighbor
+	err := lnk.exec(func() error {
+		for _, flags := range []int{0, netlink.NTF_PROXY} {
+			entries, err := listNeighs(lnk.link.Attrs().Index, family, flags)
+			if err != nil {
+				return fmt.Errorf("Failed to list the neighbors of link %s due to %s",
+					lnk.link.Attrs().Name, err.Error())
+			}
+			for _, entry := range entries {
+				// The bridge family answers the dumps of all families too
+				if entry.Family == syscall.AF_BRIDGE || entry.IP == nil {
+					continue
+				}
+				neighs = append(neighs, Neighbor{
+					IP:     entry.IP,
+					MAC:    entry.HardwareAddr,
+					State:  entry.State,
+					Proxy:  entry.Flags&netlink.NTF_PROXY != 0,
+					Router: entry.Flags&netlink.NTF_ROUTER != 0,
+				})
+			}
+		}
+		return nil
+	})
+	return neighs, err
+}
+
+// FlushNeighbors is used to delete the neighbor entries of the link in any
+// of the given states. A state of 0 flushes all the entries but the
+// permanent and noarp ones like ip neigh flush does, the proxy entries are
+// never flushed.
+func (lnk *linuxLink) FlushNeighbors(family, state int) error {
+	neighs, err := lnk.Neighbors(family)
+	if err != nil {
+		return err
+	}
+	for i := range neighs {
+		neigh := &neighs[i]
+		if neigh.Proxy {
+			continue
+		}
+		if state == 0 && neigh.State&(netlink.NUD_PERMANENT|netlink.NUD_NOARP) != 0 {
+			continue
+		}
+		if state != 0 && neigh.State&state == 0 {
+			continue
+		}
+		if err = lnk.DelNeighbor(neigh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddFDBEntry is used to add or replace a forwarding entry of the link
+func (lnk *linuxLink) AddFDBEntry(entry *FDBEntry) error {
+	return lnk.modifyFDBEntry(entry, syscall.RTM_NEWNEIGH, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE)
+}
+
+// DelFDBEntry is used to delete a forwarding entry of the link
+func (lnk *linuxLink) DelFDBEntry(entry *FDBEntry) error {
+	return lnk.modifyFDBEntry(entry, syscall.RTM_DELNEIGH, 0)
+}
+
+func (lnk *linuxLink) modifyFDBEntry(entry *FDBEntry, cmd, flags int) error {
+	if entry == nil || len(entry.MAC) != 6 {
+		return fmt.Errorf("The mac of the fdb entry is not valid")
+	}
+	if entry.VLAN < 0 || entry.VLAN > 4094 {
+		return fmt.Errorf("The vlan %d of the fdb entry is not valid", entry.VLAN)
+	}
+	if entry.Flags&^(FDBFlagSelf|FDBFlagMaster) != 0 {
+		return fmt.Errorf("The flags %#x of the fdb entry are not valid", entry.Flags)
+	}
+	msg := &netlink.Ndmsg{
+		Family: syscall.AF_BRIDGE,
+		Index:  uint32(lnk.link.Attrs().Index),
+		State:  netlink.NUD_NOARP,
+		Flags:  uint8(entry.Flags),
+	}
+	if entry.Local {
+		msg.State = netlink.NUD_PERMANENT
+	}
+	if msg.Flags == 0 {
+		msg.Flags = FDBFlagSelf
+	}
+	return lnk.exec(func() error {
+		err := neighRequest(cmd, flags, msg, entry.Dst, entry.MAC, entry.VLAN)
+		if err != nil {
+			return fmt.Errorf("Failed to modify fdb entry %s of link %s due to %s",
+				entry, lnk.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// FDBEntries is used to list the forwarding entries of the link, both the
+// ones of its own fdb and the ones of its master's fdb
+func (lnk *linuxLink) FDBEntries() ([]FDBEntry, error) {
+	var entries []FDBEntry
+	err := lnk.exec(func() error {
+		neighs, err := listNeighs(lnk.link.Attrs().Index, syscall.AF_BRIDGE, 0)
+		if err != nil {
+			return fmt.Errorf("Failed to list fdb of link %s due to %s",
+				lnk.link.Attrs().Name, err.Error())
+		}
+		for _, neigh := range neighs {
+			entries = append(entries, FDBEntry{
+				MAC:   neigh.HardwareAddr,
+				VLAN:  neigh.vlan,
+				Dst:   neigh.IP,
+				Local: neigh.State&netlink.NUD_PERMANENT != 0,
+				Flags: neigh.Flags & (FDBFlagSelf | FDBFlagMaster),
+			})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// neighRequest sends a neighbor message with the set attributes only, the
+// vendored NeighAdd always sends NDA_DST and NDA_LLADDR which the kernel
+// rejects when empty. It must run in the link's namespace.
+func neighRequest(cmd, flags int, msg *netlink.Ndmsg, ip net.IP, mac net.HardwareAddr, vlan int) error {
+	req := nl.NewNetlinkRequest(cmd, flags|syscall.NLM_F_ACK)
+	req.AddData(msg)
+	if ip != nil {
+		req.AddData(nl.NewRtAttr(netlink.NDA_DST, ipData(ip)))
+	}
+	if len(mac) != 0 {
+		req.AddData(nl.NewRtAttr(netlink.NDA_LLADDR, []byte(mac)))
+	}
+	if vlan != 0 {
+		req.AddData(nl.NewRtAttr(netlink.NDA_VLAN, nl.Uint16Attr(uint16(vlan))))
+	}
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// listNeighs dumps the neighbor messages of the link with their vlan, the
+// NTF_PROXY flag dumps the proxy entries instead. It must run in the link's
+// namespace.
+func listNeighs(index, family, flags int) ([]neighEntry, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETNEIGH, syscall.NLM_F_DUMP)
+	req.AddData(&netlink.Ndmsg{Family: uint8(family), Index: uint32(index), Flags: uint8(flags)})
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWNEIGH)
+	if err != nil {
+		return nil, err
+	}
+	native := nl.NativeEndian()
+	var entries []neighEntry
+	for _, m := range msgs {
+		neigh, err := netlink.NeighDeserialize(m)
+		if err != nil || neigh.LinkIndex != index {
+			continue
+		}
+		entry := neighEntry{Neigh: *neigh}
+		attrs, err := nl.ParseRouteAttr(m[(&netlink.Ndmsg{}).Len():])
+		if err != nil {
+			continue
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type == netlink.NDA_VLAN && len(attr.Value) >= 2 {
+				entry.vlan = int(native.Uint16(attr.Value))
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,218 @@
+package gonet
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// netemMajor is the major handle of the netem qdisc, it differs from the one
+// of the rate limiter so that either can replace the other at the root
+const netemMajor = 10
+
+// NetemProfile describes the impairments the netem qdisc adds to the packets
+// sent by a link, the percentages range from 0 to 100
+type NetemProfile struct {
+	// Delay is added to every packet, Jitter varies it up and down
+	Delay     time.Duration
+	Jitter    time.Duration
+	DelayCorr float32
+	Loss      float32
+	LossCorr  float32
+	// Duplicate is the percentage of the packets sent twice
+	Duplicate     float32
+	DuplicateCorr float32
+	// Reorder is the percentage of the packets sent at once while the other
+	// ones are delayed, it needs a Delay
+	Reorder     float32
+	ReorderCorr float32
+	// Corrupt is the percentage of the packets with a flipped bit
+	Corrupt     float32
+	CorruptCorr float32
+	// Limit is the number of packets the qdisc holds, 0 keeps the default
+	Limit uint32
+}
+
+// netemProfiles are the named profiles, they only describe one direction
+var netemProfiles = map[string]NetemProfile{
+	"lan": {Delay: 500 * time.Microsecond, Jitter: 100 * time.Microsecond},
+	"wifi": {Delay: 5 * time.Millisecond, Jitter: 3 * time.Millisecond, DelayCorr: 25,
+		Loss: 0.5, LossCorr: 25},
+	"3g": {Delay: 100 * time.Millisecond, Jitter: 40 * time.Millisecond, DelayCorr: 25,
+		Loss: 1, LossCorr: 25},
+	"4g": {Delay: 30 * time.Millisecond, Jitter: 10 * time.Millisecond, DelayCorr: 25,
+		Loss: 0.2},
+	"lossy-wan": {Delay: 40 * time.Millisecond, Jitter: 10 * time.Millisecond,
+		Loss: 5, LossCorr: 25, Duplicate: 1, Reorder: 5, ReorderCorr: 50, Corrupt: 0.1},
+	"satellite": {Delay: 300 * time.Millisecond, Jitter: 20 * time.Millisecond, Loss: 1},
+}
+
+// NetemProfileByName is used to get one of the named netem profiles
+func NetemProfileByName(name string) (NetemProfile, error) {
+	profile, ok := netemProfiles[name]
+	if !ok {
+		return NetemProfile{}, fmt.Errorf("The netem profile %s does not exist", name)
+	}
+	return profile, nil
+}
+
+// NetemProfileNames returns the names of the named netem profiles
+func NetemProfileNames() []string {
+	names := make([]string, 0, len(netemProfiles))
+	for name := range netemProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (profile *NetemProfile) validate() error {
+	if profile.Delay < 0 || profile.Jitter < 0 {
+		return fmt.Errorf("The delay and jitter of the netem profile cannot be negative")
+	}
+	if profile.Jitter > 0 && profile.Delay == 0 {
+		return fmt.Errorf("The jitter of the netem profile needs a delay")
+	}
+	if profile.Reorder > 0 && profile.Delay == 0 {
+		return fmt.Errorf("The reordering of the netem profile needs a delay")
+	}
+	for _, percent := range []float32{profile.DelayCorr, profile.Loss, profile.LossCorr,
+		profile.Duplicate, profile.DuplicateCorr, profile.Reorder, profile.ReorderCorr,
+		profile.Corrupt, profile.CorruptCorr} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("The percentage %v of the netem profile is out of range", percent)
+		}
+	}
+	return nil
+}
+
+// ApplyNetem is used to emulate the profile on the packets the link sends.
+// The netem qdisc goes under the rate limiter set by SetEgressRate or under
+// the default class of the htb tree set by ApplyHTBTree when there is one,
+// and replaces the root qdisc otherwise. Applying a profile again replaces
+// the previous one.
+func (lnk *linuxLink) ApplyNetem(profile NetemProfile) error {
+	if err := profile.validate(); err != nil {
+		return err
+	}
+	return lnk.exec(func() error {
+		qdisc, err := rootQdisc(lnk.link)
+		if err != nil {
+			return err
+		}
+		parent := uint32(netlink.HANDLE_ROOT)
+		switch root := qdisc.(type) {
+		case *netlink.Tbf:
+			if root.Handle == netlink.MakeHandle(shapingMajor, 0) {
+				parent = netlink.MakeHandle(shapingMajor, 1)
+			}
+		case *netlink.Htb:
+			if root.Handle == netlink.MakeHandle(shapingMajor, 0) {
+				if root.Defcls == 0 {
+					return fmt.Errorf("The htb tree of link %s has no default class for the netem qdisc",
+						lnk.link.Attrs().Name)
+				}
+				parent = netlink.MakeHandle(shapingMajor, uint16(root.Defcls))
+			}
+		}
+		netem := netlink.NewNetem(netlink.QdiscAttrs{
+			LinkIndex: lnk.link.Attrs().Index,
+			Handle:    netlink.MakeHandle(netemMajor, 0),
+			Parent:    parent,
+		}, netlink.NetemQdiscAttrs{
+			Latency:       uint32(profile.Delay / time.Microsecond),
+			DelayCorr:     profile.DelayCorr,
+			Limit:         profile.Limit,
+			Loss:          profile.Loss,
+			LossCorr:      profile.LossCorr,
+			Duplicate:     profile.Duplicate,
+			DuplicateCorr: profile.DuplicateCorr,
+			Jitter:        uint32(profile.Jitter / time.Microsecond),
+			ReorderProb:   profile.Reorder,
+			ReorderCorr:   profile.ReorderCorr,
+			CorruptProb:   profile.Corrupt,
+			CorruptCorr:   profile.CorruptCorr,
+		})
+		if err = netlink.QdiscReplace(netem); err != nil {
+			return fmt.Errorf("Failed to set netem qdisc of link %s due to %s",
+				lnk.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// ClearNetem is used to remove the netem qdisc set by ApplyNetem, a rate
+// limiter is kept. It does nothing when there is no netem qdisc.
+func (lnk *linuxLink) ClearNetem() error {
+	return lnk.exec(func() error {
+		netem, err := netemQdisc(lnk.link)
+		if err != nil || netem == nil {
+			return err
+		}
+		if err = netlink.QdiscDel(netem); err != nil {
+			return fmt.Errorf("Failed to delete netem qdisc of link %s due to %s",
+				lnk.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// netemQdisc returns the netem qdisc set by ApplyNetem either at the root or
+// under the rate limiter, or nil. It must run in the link's namespace.
+func netemQdisc(link netlink.Link) (*netlink.Netem, error) {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list qdiscs of link %s due to %s",
+			link.Attrs().Name, err.Error())
+	}
+	for _, qdisc := range qdiscs {
+		netem, ok := qdisc.(*netlink.Netem)
+		if ok && netem.Handle == netlink.MakeHandle(netemMajor, 0) {
+			return netem, nil
+		}
+	}
+	return nil, nil
+}
+
+// regraftNetem adds back the netem qdisc under the given parent after the
+// rate limiter came or went, it must run in the link's namespace
+func regraftNetem(link netlink.Link, netem *netlink.Netem, parent uint32) error {
+	if netem == nil {
+		return nil
+	}
+	netem.Parent = parent
+	if err := netlink.QdiscReplace(netem); err != nil {
+		return fmt.Errorf("Failed to set netem qdisc of link %s due to %s",
+			link.Attrs().Name, err.Error())
+	}
+	return nil
+}
+
+// ApplyNetem is used to emulate the profile in both directions of the pair,
+// both ends must be reachable from the pair
+func (veth *vethLinkPair) ApplyNetem(profile NetemProfile) error {
+	if veth.PeerLink == nil {
+		return fmt.Errorf("The peer of the veth link is not in this namespace")
+	}
+	if err := veth.IfcLink.ApplyNetem(profile); err != nil {
+		return err
+	}
+	if err := veth.PeerLink.ApplyNetem(profile); err != nil {
+		veth.IfcLink.ClearNetem()
+		return err
+	}
+	return nil
+}
+
+// ClearNetem is used to remove the netem qdisc from both ends of the pair
+func (veth *vethLinkPair) ClearNetem() error {
+	if err := veth.IfcLink.ClearNetem(); err != nil {
+		return err
+	}
+	if veth.PeerLink == nil {
+		return nil
+	}
+	return veth.PeerLink.ClearNetem()
+}
@@ -0,0 +1,44 @@
+package gonet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetemProfileValidate(t *testing.T) {
+	for _, name := range NetemProfileNames() {
+		profile, err := NetemProfileByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = profile.validate(); err != nil {
+			t.Errorf("The named profile %s is not valid: %v", name, err)
+		}
+	}
+	if _, err := NetemProfileByName("none"); err == nil {
+		t.Errorf("A missing profile must fail")
+	}
+
+	tests := []struct {
+		name    string
+		profile NetemProfile
+		valid   bool
+	}{
+		{"empty", NetemProfile{}, true},
+		{"loss only", NetemProfile{Loss: 100}, true},
+		{"reorder", NetemProfile{Delay: time.Millisecond, Reorder: 25, ReorderCorr: 50}, true},
+		{"negative delay", NetemProfile{Delay: -time.Millisecond}, false},
+		{"negative jitter", NetemProfile{Delay: time.Millisecond, Jitter: -1}, false},
+		{"jitter without delay", NetemProfile{Jitter: time.Millisecond}, false},
+		{"reorder without delay", NetemProfile{Reorder: 10}, false},
+		{"loss above 100", NetemProfile{Loss: 100.5}, false},
+		{"negative correlation", NetemProfile{Loss: 1, LossCorr: -1}, false},
+		{"corrupt above 100", NetemProfile{Corrupt: 101}, false},
+	}
+	for _, test := range tests {
+		err := test.profile.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package gonet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netns"
+)
+
+// WithNetNS is used to run fn inside the network namespace referred by handle.
+// The callback runs on a dedicated goroutine which is locked to its OS thread,
+// so the namespace switch never leaks to other goroutines. The original
+// namespace of the thread is always restored once fn returns. If restoring
+// fails, the thread is kept locked so that the runtime discards it instead of
+// reusing a thread stuck in the wrong namespace.
+func WithNetNS(handle netns.NsHandle, fn func() error) error {
+	if fn == nil {
+		return fmt.Errorf("The callback cannot be nil")
+	}
+	if !handle.IsOpen() {
+		return fmt.Errorf("The net ns handle is not valid")
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		errCh <- runInNetNS(handle, fn)
+	}()
+	return <-errCh
+}
+
+// runInNetNS must be called on a locked OS thread. It unlocks the thread only
+// if the original namespace has been restored successfully.
+func runInNetNS(handle netns.NsHandle, fn func() error) (err error) {
+	origNs, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Failed to get current net ns due to %s", err.Error())
+	}
+	defer origNs.Close()
+
+	err = netns.Set(handle)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Failed to switch to net ns %s due to %s", handle, err.Error())
+	}
+	defer func() {
+		if restoreErr := netns.Set(origNs); restoreErr != nil {
+			if err == nil {
+				err = fmt.Errorf("Failed to restore the original net ns due to %s",
+					restoreErr.Error())
+			}
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+	return fn()
+}
+
+// WithNetNSPath is used to run fn inside the network namespace bind mounted at path
+func WithNetNSPath(path string, fn func() error) error {
+	if path == "" {
+		return fmt.Errorf("The net ns path cannot be empty")
+	}
+	handle, err := netns.GetFromPath(path)
+	if err != nil {
+		return fmt.Errorf("Failed to get the net ns from path %s due to %s", path, err.Error())
+	}
+	defer handle.Close()
+	return WithNetNS(handle, fn)
+}
+
+// WithNetNSPid is used to run fn inside the network namespace of process pid
+func WithNetNSPid(pid int, fn func() error) error {
+	handle, err := netns.GetFromPid(pid)
+	if err != nil {
+		return fmt.Errorf("Failed to get the net ns for pid %d due to %s", pid, err.Error())
+	}
+	defer handle.Close()
+	return WithNetNS(handle, fn)
+}
+
+// NetNS is a handle to a network namespace which owns a dedicated OS thread
+// living inside that namespace. Every netlink request issued through it opens
+// its socket from that thread, so the socket is bound to the namespace while
+// the threads of the caller never call setns. Independent NetNS objects can be
+// used concurrently. A nil *NetNS refers to the namespace of the caller.
+type NetNS struct {
+	handle netns.NsHandle
+	// calls is nil for the NetNS of the moved links, which switch a locked
+	// thread on every call instead of keeping one
+	calls chan func()
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NetNSFromPid is used to open the network namespace of process pid
+func NetNSFromPid(pid int) (*NetNS, error) {
+	handle, err := netns.GetFromPid(pid)
+	if err != nil {
+		return nil, &netNSError{fmt.Sprintf("Failed to get the net ns for pid %d due to %s",
+			pid, err.Error()), err}
+	}
+	return newNetNS(handle)
+}
+
+// NetNSFromPath is used to open the network namespace bind mounted at path
+func NetNSFromPath(path string) (*NetNS, error) {
+	if path == "" {
+		return nil, fmt.Errorf("The net ns path cannot be empty")
+	}
+	handle, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, &netNSError{fmt.Sprintf("Failed to get the net ns from path %s due to %s",
+			path, err.Error()), err}
+	}
+	return newNetNS(handle)
+}
+
+// NetNSFromName is used to open the named network namespace created by `ip netns`
+func NetNSFromName(name string) (*NetNS, error) {
+	path, err := namedNetNSPath(name)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, &netNSError{fmt.Sprintf("Failed to get the net ns %s due to %s",
+			name, err.Error()), err}
+	}
+	return newNetNS(handle)
+}
+
+// NetNSFromDocker is used to open the network namespace of a docker container
+func NetNSFromDocker(containerID string) (*NetNS, error) {
+	handle, err := dockerNetNSHandle(containerID)
+	if err != nil {
+		return nil, err
+	}
+	return newNetNS(handle)
+}
+
+// netNSError is returned when a namespace cannot be opened, it keeps the
+// cause so that a namespace which is gone can be told from other failures
+type netNSError struct {
+	msg   string
+	cause error
+}
+
+func (e *netNSError) Error() string {
+	return e.msg
+}
+
+func (e *netNSError) Unwrap() error {
+	return e.cause
+}
+
+// isNetNSGone tells whether err comes from a namespace, or the process
+// holding it, which does not exist anymore
+func isNetNSGone(err error) bool {
+	return errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ESRCH)
+}
+
+// newNetNS takes the ownership of handle and starts the namespace thread
+func newNetNS(handle netns.NsHandle) (*NetNS, error) {
+	ns := &NetNS{
+		handle: handle,
+		calls:  make(chan func()),
+		done:   make(chan struct{}),
+	}
+	ready := make(chan error, 1)
+	go ns.serve(ready)
+	if err := <-ready; err != nil {
+		handle.Close()
+		return nil, err
+	}
+	return ns, nil
+}
+
+// lightNetNS takes the ownership of handle and returns a NetNS without a
+// thread of its own, every call runs through WithNetNS. It backs the links
+// moved into a namespace the caller keeps no NetNS of, the handle is closed
+// once the NetNS is garbage collected.
+func lightNetNS(handle netns.NsHandle) *NetNS {
+	ns := &NetNS{handle: handle, done: make(chan struct{})}
+	runtime.SetFinalizer(ns, (*NetNS).Close)
+	return ns
+}
+
+// dupNetNS returns a lightNetNS on a duplicate of handle, which stays valid
+// once handle is closed
+func dupNetNS(handle netns.NsHandle) (*NetNS, error) {
+	fd, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(handle), syscall.F_DUPFD_CLOEXEC, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("Failed to duplicate net ns handle %s due to %s", handle, errno.Error())
+	}
+	return lightNetNS(netns.NsHandle(fd)), nil
+}
+
+// keepNetNS returns a NetNS of the namespace of ns for the links moved into
+// it. A NetNS the caller does not own outlives the links and is used as is,
+// an owned one is about to be closed so its handle is duplicated.
+func keepNetNS(ns *NetNS, owned bool) (*NetNS, error) {
+	if !owned {
+		return ns, nil
+	}
+	return dupNetNS(ns.handle)
+}
+
+// serve pins the goroutine to an OS thread inside the namespace and runs the
+// submitted calls until the NetNS is closed. The thread is never unlocked once
+// it has entered the namespace, so the runtime terminates it on exit instead
+// of handing it to other goroutines.
+func (ns *NetNS) serve(ready chan<- error) {
+	runtime.LockOSThread()
+	if err := netns.Set(ns.handle); err != nil {
+		runtime.UnlockOSThread()
+		ready <- fmt.Errorf("Failed to switch to net ns %s due to %s", ns.handle, err.Error())
+		return
+	}
+	ready <- nil
+	for {
+		select {
+		case call := <-ns.calls:
+			call()
+		case <-ns.done:
+			return
+		}
+	}
+}
+
+// Do is used to run fn on the thread of the namespace. Do must not be called
+// from within fn since the namespace thread runs the calls one at a time.
+func (ns *NetNS) Do(fn func() error) error {
+	if fn == nil {
+		return fmt.Errorf("The callback cannot be nil")
+	}
+	if ns.calls == nil {
+		if ns.isClosed() {
+			return fmt.Errorf("The net ns has been closed")
+		}
+		return WithNetNS(ns.handle, fn)
+	}
+	errCh := make(chan error, 1)
+	call := func() { errCh <- fn() }
+	select {
+	case ns.calls <- call:
+		return <-errCh
+	case <-ns.done:
+		return fmt.Errorf("The net ns has been closed")
+	}
+}
+
+// run executes fn inside the namespace, or directly for the caller's namespace
+func (ns *NetNS) run(fn func() error) error {
+	if ns == nil {
+		return fn()
+	}
+	return ns.Do(fn)
+}
+
+// isClosed tells whether Close has been called, the caller's namespace never is
+func (ns *NetNS) isClosed() bool {
+	if ns == nil {
+		return false
+	}
+	select {
+	case <-ns.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sameNetNS tells whether a and b refer to the same namespace, two NetNS
+// opened on one namespace hold different handles
+func sameNetNS(a, b *NetNS) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+	if a != nil && b != nil {
+		return a.handle.Equal(b.handle), nil
+	}
+	if a == nil {
+		a = b
+	}
+	current, err := netns.Get()
+	if err != nil {
+		return false, fmt.Errorf("Failed to get current net ns due to %s", err.Error())
+	}
+	defer current.Close()
+	return a.handle.Equal(current), nil
+}
+
+// threadNetNS returns a NetNS of the namespace of the calling thread, which
+// the caller must close. It is nil when the thread lives in the namespace of
+// the process, like every thread which is not locked into another one.
+func threadNetNS() (*NetNS, error) {
+	current, err := netns.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get current net ns due to %s", err.Error())
+	}
+	process, err := netns.GetFromPid(os.Getpid())
+	if err != nil {
+		current.Close()
+		return nil, fmt.Errorf("Failed to get the net ns of the process due to %s", err.Error())
+	}
+	defer process.Close()
+	if current.Equal(process) {
+		current.Close()
+		return nil, nil
+	}
+	return lightNetNS(current), nil
+}
+
+// Handle returns the underlying namespace handle, which stays owned by ns
+func (ns *NetNS) Handle() netns.NsHandle {
+	return ns.handle
+}
+
+// Close is used to stop the namespace thread and release the handle
+func (ns *NetNS) Close() error {
+	var err error
+	ns.once.Do(func() {
+		close(ns.done)
+		err = ns.handle.Close()
+	})
+	return err
+}
+
+// NetNSRef refers to a network namespace targeted by an operation. It is
+// implemented by *NetNS and by the values returned from PidNetNS, PathNetNS,
+// NamedNetNS and DockerNetNS.
+type NetNSRef interface {
+	// netNS returns a NetNS of the namespace and whether the caller must
+	// close it once done
+	netNS() (*NetNS, bool, error)
+}
+
+func (ns *NetNS) netNS() (*NetNS, bool, error) {
+	if ns == nil {
+		return nil, false, fmt.Errorf("The net ns cannot be nil")
+	}
+	return ns, false, nil
+}
+
+// netNSOpener is a NetNSRef opening a new NetNS on every use
+type netNSOpener func() (*NetNS, error)
+
+func (open netNSOpener) netNS() (*NetNS, bool, error) {
+	ns, err := open()
+	return ns, err == nil, err
+}
+
+// PidNetNS refers to the network namespace of process pid
+func PidNetNS(pid int) NetNSRef {
+	return netNSOpener(func() (*NetNS, error) { return NetNSFromPid(pid) })
+}
+
+// PathNetNS refers to the network namespace bind mounted at path
+func PathNetNS(path string) NetNSRef {
+	return netNSOpener(func() (*NetNS, error) { return NetNSFromPath(path) })
+}
+
+// NamedNetNS refers to a named network namespace created by `ip netns`
+func NamedNetNS(name string) NetNSRef {
+	return netNSOpener(func() (*NetNS, error) { return NetNSFromName(name) })
+}
+
+// DockerNetNS refers to the network namespace of a docker container
+func DockerNetNS(containerID string) NetNSRef {
+	return netNSOpener(func() (*NetNS, error) { return NetNSFromDocker(containerID) })
+}
@@ -0,0 +1,275 @@
+package gonet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The default locations the container runtimes listen on or keep their state in
+const (
+	DockerSocket       = "/var/run/docker.sock"
+	PodmanSocket       = "/run/podman/podman.sock"
+	CRIOSocket         = "/var/run/crio/crio.sock"
+	ContainerdStateDir = "/run/containerd/io.containerd.runtime.v2.task"
+	RuncStateDir       = "/run/runc"
+)
+
+// resolverTimeout bounds the requests sent to the runtime sockets
+const resolverTimeout = 10 * time.Second
+
+// NamespaceResolver finds the network namespace of a container of a given
+// container runtime
+type NamespaceResolver interface {
+	// NetNSPath returns a path the network namespace of the container can be
+	// opened from
+	NetNSPath(id string) (string, error)
+}
+
+var (
+	resolversLock sync.RWMutex
+	resolvers     = map[string]NamespaceResolver{
+		"docker":     &DockerResolver{Socket: DockerSocket},
+		"podman":     &DockerResolver{Socket: PodmanSocket},
+		"crio":       &CRIOResolver{Socket: CRIOSocket},
+		"containerd": &ContainerdResolver{StateDir: ContainerdStateDir},
+		"runc":       &OCIStateResolver{Root: RuncStateDir},
+	}
+)
+
+// RegisterNamespaceResolver is used to add or replace the resolver of the
+// runtime, the built in ones are docker, podman, crio, containerd and runc
+func RegisterNamespaceResolver(runtime string, resolver NamespaceResolver) error {
+	if runtime == "" {
+		return fmt.Errorf("The runtime name cannot be empty")
+	}
+	if resolver == nil {
+		return fmt.Errorf("The namespace resolver cannot be nil")
+	}
+	resolversLock.Lock()
+	defer resolversLock.Unlock()
+	resolvers[runtime] = resolver
+	return nil
+}
+
+// ContainerNetNSPath is used to find the network namespace of the container
+// through the resolver registered for the runtime
+func ContainerNetNSPath(runtime, id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("The container id cannot be empty")
+	}
+	resolversLock.RLock()
+	resolver, ok := resolvers[runtime]
+	resolversLock.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("The container runtime %s is not supported", runtime)
+	}
+	path, err := resolver.NetNSPath(id)
+	if err != nil {
+		return "", &netNSError{fmt.Sprintf("Failed to find the net ns of %s container %s due to %s",
+			runtime, id, err.Error()), err}
+	}
+	return path, nil
+}
+
+// ContainerNetNS refers to the network namespace of a container of the runtime
+func ContainerNetNS(runtime, id string) NetNSRef {
+	return netNSOpener(func() (*NetNS, error) {
+		path, err := ContainerNetNSPath(runtime, id)
+		if err != nil {
+			return nil, err
+		}
+		return NetNSFromPath(path)
+	})
+}
+
+// SetToContainerNs is used to put the link into the namespace of a container
+// of the runtime with the new name
+func (lnk *linuxLink) SetToContainerNs(runtime, id, newName string, config *IPConfig) error {
+	return lnk.SetToNs(ContainerNetNS(runtime, id), newName, config)
+}
+
+// DockerResolver asks the docker engine API for the pid of the container,
+// podman serves the same API on its own socket
+type DockerResolver struct {
+	Socket string
+}
+
+// NetNSPath returns the network namespace of the container's init process
+func (r *DockerResolver) NetNSPath(id string) (string, error) {
+	var inspect struct {
+		State struct {
+			Running bool
+			Pid     int
+		}
+	}
+	err := getUnixJSON(r.Socket, "/containers/"+url.PathEscape(id)+"/json", &inspect)
+	if err != nil {
+		return "", err
+	}
+	if !inspect.State.Running {
+		return "", &netNSError{fmt.Sprintf("The container %s is not running", id), syscall.ESRCH}
+	}
+	return pidNetNSPath(inspect.State.Pid)
+}
+
+// CRIOResolver asks the inspect endpoint of the CRI-O socket for the pid of
+// the container, sandbox ids resolve to the infra container
+type CRIOResolver struct {
+	Socket string
+}
+
+// NetNSPath returns the network namespace of the container's init process
+func (r *CRIOResolver) NetNSPath(id string) (string, error) {
+	var info struct {
+		Pid int `json:"pid"`
+	}
+	err := getUnixJSON(r.Socket, "/containers/"+url.PathEscape(id), &info)
+	if err != nil {
+		return "", err
+	}
+	return pidNetNSPath(info.Pid)
+}
+
+// ContainerdResolver reads the pid of the container from the state directory
+// of the containerd v2 runtime shims, laid out as <StateDir>/<namespace>/<id>
+type ContainerdResolver struct {
+	StateDir string
+	// Namespace is the containerd namespace of the container, such as k8s.io
+	// or moby, empty searches all of them
+	Namespace string
+}
+
+// NetNSPath returns the network namespace of the container's init process
+func (r *ContainerdResolver) NetNSPath(id string) (string, error) {
+	if err := checkContainerID(id); err != nil {
+		return "", err
+	}
+	namespaces := []string{r.Namespace}
+	if r.Namespace == "" {
+		infos, err := ioutil.ReadDir(r.StateDir)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read %s due to %s", r.StateDir, err.Error())
+		}
+		namespaces = nil
+		for _, info := range infos {
+			if info.IsDir() {
+				namespaces = append(namespaces, info.Name())
+			}
+		}
+	}
+	for _, namespace := range namespaces {
+		data, err := ioutil.ReadFile(filepath.Join(r.StateDir, namespace, id, "init.pid"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("Failed to read the pid of container %s due to %s", id, err.Error())
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return "", fmt.Errorf("The pid file of container %s is not valid", id)
+		}
+		return pidNetNSPath(pid)
+	}
+	return "", &netNSError{fmt.Sprintf("The container %s does not exist", id), syscall.ENOENT}
+}
+
+// OCIStateResolver reads the state.json runc and the runc compatible runtimes
+// keep under <Root>/<id>
+type OCIStateResolver struct {
+	Root string
+}
+
+// NetNSPath returns the network namespace the container joined, or the one
+// of its init process
+func (r *OCIStateResolver) NetNSPath(id string) (string, error) {
+	if err := checkContainerID(id); err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(r.Root, id, "state.json"))
+	if err != nil {
+		return "", &netNSError{fmt.Sprintf("Failed to read the state of container %s due to %s",
+			id, err.Error()), err}
+	}
+	var state struct {
+		InitProcessPid int               `json:"init_process_pid"`
+		NamespacePaths map[string]string `json:"namespace_paths"`
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return "", fmt.Errorf("Failed to parse the state of container %s due to %s", id, err.Error())
+	}
+	if path := state.NamespacePaths["NEWNET"]; path != "" {
+		if _, err = os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return pidNetNSPath(state.InitProcessPid)
+}
+
+// getUnixJSON sends a GET request to the HTTP server listening on the unix
+// socket and decodes its JSON answer into v. A 404 answer means the container
+// does not exist, a socket which cannot be reached tells nothing about it.
+func getUnixJSON(socket, path string, v interface{}) error {
+	dialer := &net.Dialer{Timeout: resolverTimeout}
+	client := &http.Client{
+		Timeout: resolverTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	resp, err := client.Get("http://localhost" + path)
+	if err != nil {
+		return fmt.Errorf("Failed to query %s due to %s", socket, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		msg := fmt.Sprintf("%s returned %s %s", socket, resp.Status, strings.TrimSpace(string(body)))
+		if resp.StatusCode == http.StatusNotFound {
+			return &netNSError{msg, syscall.ENOENT}
+		}
+		return errors.New(msg)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Failed to decode the answer of %s due to %s", socket, err.Error())
+	}
+	return nil
+}
+
+// pidNetNSPath returns the network namespace path of a running process
+func pidNetNSPath(pid int) (string, error) {
+	if pid <= 0 {
+		return "", &netNSError{"The container has no running process", syscall.ESRCH}
+	}
+	path := fmt.Sprintf("/proc/%d/ns/net", pid)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			err = syscall.ESRCH
+		}
+		return "", &netNSError{fmt.Sprintf("The process %d of the container is gone", pid), err}
+	}
+	return path, nil
+}
+
+// checkContainerID rejects the ids which would escape the state directories
+func checkContainerID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsRune(id, '/') {
+		return fmt.Errorf("The container id %s is not valid", id)
+	}
+	return nil
+}
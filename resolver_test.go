@@ -0,0 +1,208 @@
+package gonet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveUnix serves handler on a unix socket inside dir and returns its path
+func serveUnix(t *testing.T, dir string, handler http.Handler) string {
+	socket := filepath.Join(dir, "runtime.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s due to %s", socket, err.Error())
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return socket
+}
+
+func selfNetNSPath() string {
+	return fmt.Sprintf("/proc/%d/ns/net", os.Getpid())
+}
+
+// resolverTest is a lookup of id through the resolver of the test, or
+// through its own resolver when it sets one
+type resolverTest struct {
+	name     string
+	resolver NamespaceResolver
+	id       string
+	path     string
+	gone     bool
+}
+
+// checkResolver runs the lookups, a test without path expects an error which
+// tells whether the container is gone
+func checkResolver(t *testing.T, resolver NamespaceResolver, tests []resolverTest) {
+	for _, test := range tests {
+		name := test.name
+		if name == "" {
+			name = test.id
+		}
+		r := resolver
+		if test.resolver != nil {
+			r = test.resolver
+		}
+		path, err := r.NetNSPath(test.id)
+		if test.path != "" {
+			if err != nil || path != test.path {
+				t.Errorf("%s: got %q, %v, want %q", name, path, err, test.path)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: got %q, want an error", name, path)
+		} else if isNetNSGone(err) != test.gone {
+			t.Errorf("%s: gone is %v for %v", name, !test.gone, err)
+		}
+	}
+}
+
+// answerPaths serves the answers by url path, the other paths are not found
+func answerPaths(answers map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		answer, ok := answers[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"message":"No such container"}`, http.StatusNotFound)
+			return
+		}
+		if answer == "" {
+			http.Error(w, `{"message":"server error"}`, http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, answer)
+	})
+}
+
+func TestDockerResolver(t *testing.T) {
+	socket := serveUnix(t, t.TempDir(), answerPaths(map[string]string{
+		"/containers/running/json": fmt.Sprintf(`{"State":{"Running":true,"Pid":%d}}`, os.Getpid()),
+		"/containers/stopped/json": `{"State":{"Running":false,"Pid":0}}`,
+		"/containers/broken/json":  `{"State":`,
+		"/containers/failing/json": "",
+	}))
+	missingSocket := &DockerResolver{Socket: filepath.Join(t.TempDir(), "none.sock")}
+	checkResolver(t, &DockerResolver{Socket: socket}, []resolverTest{
+		{id: "running", path: selfNetNSPath()},
+		{id: "stopped", gone: true},
+		{id: "missing", gone: true},
+		{id: "broken"},
+		{id: "failing"},
+		{name: "missing socket", resolver: missingSocket, id: "running"},
+	})
+}
+
+func TestCRIOResolver(t *testing.T) {
+	socket := serveUnix(t, t.TempDir(), answerPaths(map[string]string{
+		"/containers/running": fmt.Sprintf(`{"pid":%d}`, os.Getpid()),
+		"/containers/exited":  `{"pid":0}`,
+		"/containers/gone":    `{"pid":999999999}`,
+		"/containers/failing": "",
+	}))
+	checkResolver(t, &CRIOResolver{Socket: socket}, []resolverTest{
+		{id: "running", path: selfNetNSPath()},
+		{id: "exited", gone: true},
+		{id: "gone", gone: true},
+		{id: "missing", gone: true},
+		{id: "failing"},
+	})
+}
+
+// writeFile creates the file and its directories
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContainerdResolver(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "k8s.io", "pod", "init.pid"), fmt.Sprintf("%d\n", os.Getpid()))
+	writeFile(t, filepath.Join(dir, "moby", "bad", "init.pid"), "not a pid")
+	inNamespace := func(namespace string) NamespaceResolver {
+		return &ContainerdResolver{StateDir: dir, Namespace: namespace}
+	}
+
+	checkResolver(t, inNamespace(""), []resolverTest{
+		{name: "namespaced", resolver: inNamespace("k8s.io"), id: "pod", path: selfNetNSPath()},
+		{id: "pod", path: selfNetNSPath()},
+		{name: "other namespace", resolver: inNamespace("moby"), id: "pod", gone: true},
+		{id: "missing", gone: true},
+		{id: "bad"},
+		{id: "../k8s.io/pod"},
+		{id: ".."},
+		{name: "missing state dir", resolver: &ContainerdResolver{StateDir: filepath.Join(dir, "none")},
+			id: "pod"},
+	})
+}
+
+func TestOCIStateResolver(t *testing.T) {
+	dir := t.TempDir()
+	nsFile := filepath.Join(dir, "netns")
+	writeFile(t, nsFile, "")
+	writeFile(t, filepath.Join(dir, "joined", "state.json"),
+		fmt.Sprintf(`{"init_process_pid":1,"namespace_paths":{"NEWNET":%q}}`, nsFile))
+	writeFile(t, filepath.Join(dir, "own", "state.json"),
+		fmt.Sprintf(`{"init_process_pid":%d,"namespace_paths":{"NEWNET":"/nonexistent/netns"}}`, os.Getpid()))
+	writeFile(t, filepath.Join(dir, "stopped", "state.json"), `{"init_process_pid":0}`)
+	writeFile(t, filepath.Join(dir, "broken", "state.json"), `{"init_process_pid":`)
+
+	checkResolver(t, &OCIStateResolver{Root: dir}, []resolverTest{
+		{id: "joined", path: nsFile},
+		{id: "own", path: selfNetNSPath()},
+		{id: "stopped", gone: true},
+		{id: "missing", gone: true},
+		{id: "broken"},
+		{id: "a/b"},
+	})
+}
+
+type fixedResolver string
+
+func (r fixedResolver) NetNSPath(id string) (string, error) {
+	if id != "known" {
+		return "", fmt.Errorf("The container %s does not exist", id)
+	}
+	return string(r), nil
+}
+
+func TestContainerNetNSPath(t *testing.T) {
+	if err := RegisterNamespaceResolver("", fixedResolver("")); err == nil {
+		t.Errorf("An empty runtime name must fail")
+	}
+	if err := RegisterNamespaceResolver("test", nil); err == nil {
+		t.Errorf("A nil resolver must fail")
+	}
+	if err := RegisterNamespaceResolver("test", fixedResolver("/run/test/ns")); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := ContainerNetNSPath("test", "known"); err != nil || path != "/run/test/ns" {
+		t.Errorf("got %q, %v, want /run/test/ns", path, err)
+	}
+	for _, test := range []struct {
+		runtime string
+		id      string
+		want    string
+	}{
+		{runtime: "test", id: "", want: "cannot be empty"},
+		{runtime: "test", id: "other", want: "does not exist"},
+		{runtime: "unknown", id: "known", want: "not supported"},
+	} {
+		_, err := ContainerNetNSPath(test.runtime, test.id)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s/%s: got %v, want an error containing %q", test.runtime, test.id, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// Route describes a route entry of a namespace
+type Route struct {
+	// Dst is the destination network, nil for the default route
+	Dst *net.IPNet
+	Gw  net.IP
+	// Src is the preferred source address hint
+	Src net.IP
+	// LinkName is the name of the outgoing link, it is ignored by the
+	// LinuxLink route methods which use the link itself
+	LinkName string
+	// Table is the routing table, 0 for the main table
+	Table int
+	// Metric is the route priority, lower is preferred
+	Metric int
+	// Scope defaults to link for routes without any gateway
+	Scope netlink.Scope
+	// OnLink tells the kernel the gateway is reachable even if no prefix
+	// of the link covers it
+	OnLink bool
+	// MultiPath holds the next hops of an ECMP route, Gw and LinkName of
+	// the route are not used when it is set
+	MultiPath []*NextHop
+}
+
+// NextHop is one of the paths of a multipath route
+type NextHop struct {
+	Gw       net.IP
+	LinkName string
+	// Weight of the path, from 1 to 256, 0 means 1
+	Weight int
+	OnLink bool
+}
+
+func (r Route) String() string {
+	dst := "default"
+	if r.Dst != nil {
+		dst = r.Dst.String()
+	}
+	if len(r.MultiPath) > 0 {
+		return fmt.Sprintf("%s table %d metric %d multipath %d hops", dst, r.Table, r.Metric,
+			len(r.MultiPath))
+	}
+	return fmt.Sprintf("%s via %s src %s dev %s table %d metric %d", dst, r.Gw, r.Src,
+		r.LinkName, r.Table, r.Metric)
+}
+
+// AddRoute is used to add a route to the current namespace
+func AddRoute(route *Route) error {
+	return modifyRoute(nil, route, 0, syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)
+}
+
+// AddRoute is used to add a route inside the namespace
+func (ns *NetNS) AddRoute(route *Route) error {
+	return modifyRoute(ns, route, 0, syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)
+}
+
+// ReplaceRoute is used to add or replace a route in the current namespace
+func ReplaceRoute(route *Route) error {
+	return modifyRoute(nil, route, 0, syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE)
+}
+
+// ReplaceRoute is used to add or replace a route inside the namespace
+func (ns *NetNS) ReplaceRoute(route *Route) error {
+	return modifyRoute(ns, route, 0, syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE)
+}
+
+// DelRoute is used to delete a route from the current namespace
+func DelRoute(route *Route) error {
+	return modifyRoute(nil, route, 0, syscall.RTM_DELROUTE, 0)
+}
+
+// DelRoute is used to delete a route inside the namespace
+func (ns *NetNS) DelRoute(route *Route) error {
+	return modifyRoute(ns, route, 0, syscall.RTM_DELROUTE, 0)
+}
+
+// Routes is used to list the routes of the current namespace, family is
+// one of netlink.FAMILY_ALL, netlink.FAMILY_V4 and netlink.FAMILY_V6. The
+// routes of all the tables but the local one are returned.
+func Routes(family int) ([]Route, error) {
+	return listRoutes(nil, family, 0)
+}
+
+// Routes is used to list the routes inside the namespace
+func (ns *NetNS) Routes(family int) ([]Route, error) {
+	return listRoutes(ns, family, 0)
+}
+
+// AddRoute is used to add a route going out through the link
+func (lnk *linuxLink) AddRoute(route *Route) error {
+	return lnk.modifyRoute(route, syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)
+}
+
+// DelRoute is used to delete a route going out through the link
+func (lnk *linuxLink) DelRoute(route *Route) error {
+	return lnk.modifyRoute(route, syscall.RTM_DELROUTE, 0)
+}
+
+// Routes is used to list the routes going out through the link
+func (lnk *linuxLink) Routes(family int) ([]Route, error) {
+	return listRoutes(lnk.ns, family, lnk.link.Attrs().Index)
+}
+
+// SetDefaultGateway is used to add or replace the default route of the
+// family of gw in the main table so that it goes through gw on the link
+func (lnk *linuxLink) SetDefaultGateway(gw net.IP) error {
+	if gw == nil {
+		return fmt.Errorf("The gateway cannot be empty")
+	}
+	return lnk.modifyRoute(&Route{Gw: gw}, syscall.RTM_NEWROUTE,
+		syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE)
+}
+
+func (lnk *linuxLink) modifyRoute(route *Route, cmd, flags int) error {
+	return modifyRoute(lnk.ns, route, lnk.link.Attrs().Index, cmd, flags)
+}
+
+// modifyRoute sends the route request inside ns, a non zero linkIndex
+// overrides the LinkName of the route
+func modifyRoute(ns *NetNS, route *Route, linkIndex, cmd, flags int) error {
+	if route == nil {
+		return fmt.Errorf("The route cannot be nil")
+	}
+	return ns.run(func() error {
+		err := execRouteRequest(route, linkIndex, cmd, flags)
+		if err != nil {
+			action := "add"
+			if cmd == syscall.RTM_DELROUTE {
+				action = "delete"
+			}
+			return fmt.Errorf("Failed to %s route %s due to %s", action, route, err.Error())
+		}
+		return nil
+	})
+}
+
+// execRouteRequest builds and sends the request, it must run in the route's
+// namespace. The vendored RouteAdd has no support for multipath routes.
+func execRouteRequest(route *Route, linkIndex, cmd, flags int) error {
+	var msg *nl.RtMsg
+	if cmd == syscall.RTM_DELROUTE {
+		msg = nl.NewRtDelMsg()
+	} else {
+		msg = nl.NewRtMsg()
+	}
+	family := -1
+	var attrs []*nl.RtAttr
+	setFamily := func(ip net.IP) error {
+		ipFamily := nl.GetIPFamily(ip)
+		if family != -1 && family != ipFamily {
+			return fmt.Errorf("The addresses of the route are not of the same family")
+		}
+		family = ipFamily
+		return nil
+	}
+
+	if route.Dst != nil && route.Dst.IP != nil {
+		if err := setFamily(route.Dst.IP); err != nil {
+			return err
+		}
+		dstLen, _ := route.Dst.Mask.Size()
+		msg.Dst_len = uint8(dstLen)
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_DST, ipData(route.Dst.IP)))
+	}
+	if route.Src != nil {
+		if err := setFamily(route.Src); err != nil {
+			return err
+		}
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_PREFSRC, ipData(route.Src)))
+	}
+
+	if len(route.MultiPath) > 0 {
+		multiPath, err := encodeMultiPath(route.MultiPath, setFamily)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_MULTIPATH, multiPath))
+	} else {
+		if route.Gw != nil {
+			if err := setFamily(route.Gw); err != nil {
+				return err
+			}
+			attrs = append(attrs, nl.NewRtAttr(syscall.RTA_GATEWAY, ipData(route.Gw)))
+		}
+		if linkIndex == 0 && route.LinkName != "" {
+			link, err := netlink.LinkByName(route.LinkName)
+			if err != nil {
+				return fmt.Errorf("Failed to find the link with name %s due to %s",
+					route.LinkName, err.Error())
+			}
+			linkIndex = link.Attrs().Index
+		}
+		if linkIndex != 0 {
+			attrs = append(attrs, nl.NewRtAttr(syscall.RTA_OIF, nl.Uint32Attr(uint32(linkIndex))))
+		}
+		if route.OnLink {
+			msg.Flags |= syscall.RTNH_F_ONLINK
+		}
+	}
+	if family == -1 {
+		return fmt.Errorf("One of the destination, source or gateway must be given")
+	}
+
+	if route.Table > 0 {
+		if route.Table >= 256 {
+			msg.Table = syscall.RT_TABLE_UNSPEC
+		} else {
+			msg.Table = uint8(route.Table)
+		}
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_TABLE, nl.Uint32Attr(uint32(route.Table))))
+	}
+	if route.Metric > 0 {
+		attrs = append(attrs, nl.NewRtAttr(syscall.RTA_PRIORITY, nl.Uint32Attr(uint32(route.Metric))))
+	}
+	if cmd != syscall.RTM_DELROUTE {
+		msg.Scope = uint8(route.Scope)
+		if route.Scope == netlink.SCOPE_UNIVERSE && route.Gw == nil &&
+			len(route.MultiPath) == 0 && route.Dst != nil {
+			msg.Scope = uint8(netlink.SCOPE_LINK)
+		}
+	}
+	msg.Family = uint8(family)
+
+	req := nl.NewNetlinkRequest(cmd, flags|syscall.NLM_F_ACK)
+	req.AddData(msg)
+	for _, attr := range attrs {
+		req.AddData(attr)
+	}
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// encodeMultiPath serializes the next hops as a list of struct rtnexthop,
+// each one followed by its RTA_GATEWAY attribute
+func encodeMultiPath(hops []*NextHop, setFamily func(net.IP) error) ([]byte, error) {
+	native := nl.NativeEndian()
+	var buf []byte
+	for _, hop := range hops {
+		if hop == nil {
+			return nil, fmt.Errorf("The next hop cannot be nil")
+		}
+		if hop.Weight < 0 || hop.Weight > 256 {
+			return nil, fmt.Errorf("The weight %d of the next hop is not valid", hop.Weight)
+		}
+		var gwAttr []byte
+		if hop.Gw != nil {
+			if err := setFamily(hop.Gw); err != nil {
+				return nil, err
+			}
+			gwAttr = nl.NewRtAttr(syscall.RTA_GATEWAY, ipData(hop.Gw)).Serialize()
+		}
+		ifindex := 0
+		if hop.LinkName != "" {
+			link, err := netlink.LinkByName(hop.LinkName)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to find the link with name %s due to %s",
+					hop.LinkName, err.Error())
+			}
+			ifindex = link.Attrs().Index
+		}
+		weight := hop.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		header := make([]byte, syscall.SizeofRtNexthop)
+		native.PutUint16(header[0:2], uint16(syscall.SizeofRtNexthop+len(gwAttr)))
+		if hop.OnLink {
+			header[2] = syscall.RTNH_F_ONLINK
+		}
+		header[3] = uint8(weight - 1)
+		native.PutUint32(header[4:8], uint32(ifindex))
+		buf = append(buf, header...)
+		buf = append(buf, gwAttr...)
+	}
+	return buf, nil
+}
+
+// listRoutes dumps the routes inside ns, a non zero linkIndex keeps only the
+// routes having the link as outgoing link or as one of their next hops
+func listRoutes(ns *NetNS, family, linkIndex int) ([]Route, error) {
+	var routes []Route
+	err := ns.run(func() error {
+		req := nl.NewNetlinkRequest(syscall.RTM_GETROUTE, syscall.NLM_F_DUMP)
+		req.AddData(nl.NewIfInfomsg(family))
+		msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWROUTE)
+		if err != nil {
+			return err
+		}
+		names := make(map[int]string)
+		for _, m := range msgs {
+			msg := nl.DeserializeRtMsg(m)
+			if msg.Flags&syscall.RTM_F_CLONED != 0 || msg.Table == syscall.RT_TABLE_LOCAL {
+				continue
+			}
+			route, indexes, err := parseRoute(m, names)
+			if err != nil {
+				return err
+			}
+			if linkIndex != 0 && !containsIndex(indexes, linkIndex) {
+				continue
+			}
+			routes = append(routes, route)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list routes due to %s", err.Error())
+	}
+	return routes, nil
+}
+
+// parseRoute decodes a RTM_NEWROUTE message and returns the link indexes it
+// goes through. names caches the link names by index.
+func parseRoute(m []byte, names map[int]string) (Route, []int, error) {
+	msg := nl.DeserializeRtMsg(m)
+	attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+	if err != nil {
+		return Route{}, nil, err
+	}
+	native := nl.NativeEndian()
+	route := Route{
+		Table:  int(msg.Table),
+		Scope:  netlink.Scope(msg.Scope),
+		OnLink: msg.Flags&syscall.RTNH_F_ONLINK != 0,
+	}
+	var indexes []int
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case syscall.RTA_DST:
+			route.Dst = &net.IPNet{
+				IP:   net.IP(attr.Value),
+				Mask: net.CIDRMask(int(msg.Dst_len), 8*len(attr.Value)),
+			}
+		case syscall.RTA_PREFSRC:
+			route.Src = net.IP(attr.Value)
+		case syscall.RTA_GATEWAY:
+			route.Gw = net.IP(attr.Value)
+		case syscall.RTA_OIF:
+			index := int(native.Uint32(attr.Value[0:4]))
+			indexes = append(indexes, index)
+			route.LinkName = linkNameByIndex(index, names)
+		case syscall.RTA_PRIORITY:
+			route.Metric = int(native.Uint32(attr.Value[0:4]))
+		case syscall.RTA_TABLE:
+			route.Table = int(native.Uint32(attr.Value[0:4]))
+		case syscall.RTA_MULTIPATH:
+			value := attr.Value
+			for len(value) >= syscall.SizeofRtNexthop {
+				hopLen := int(native.Uint16(value[0:2]))
+				if hopLen < syscall.SizeofRtNexthop || hopLen > len(value) {
+					break
+				}
+				index := int(native.Uint32(value[4:8]))
+				indexes = append(indexes, index)
+				hop := &NextHop{
+					LinkName: linkNameByIndex(index, names),
+					Weight:   int(value[3]) + 1,
+					OnLink:   value[2]&syscall.RTNH_F_ONLINK != 0,
+				}
+				hopAttrs, err := nl.ParseRouteAttr(value[syscall.SizeofRtNexthop:hopLen])
+				if err != nil {
+					return Route{}, nil, err
+				}
+				for _, hopAttr := range hopAttrs {
+					if hopAttr.Attr.Type == syscall.RTA_GATEWAY {
+						hop.Gw = net.IP(hopAttr.Value)
+					}
+				}
+				route.MultiPath = append(route.MultiPath, hop)
+				// The padding of the last hop may be missing
+				if rtaAlign(hopLen) > len(value) {
+					break
+				}
+				value = value[rtaAlign(hopLen):]
+			}
+		}
+	}
+	if route.Table == syscall.RT_TABLE_MAIN {
+		route.Table = 0
+	}
+	return route, indexes, nil
+}
+
+func linkNameByIndex(index int, names map[int]string) string {
+	name, ok := names[index]
+	if !ok {
+		if link, err := netlink.LinkByIndex(index); err == nil {
+			name = link.Attrs().Name
+		}
+		names[index] = name
+	}
+	return name
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, i := range indexes {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+func ipData(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+func rtaAlign(length int) int {
+	return (length + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+}
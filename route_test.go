@@ -0,0 +1,216 @@
+package gonet
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+)
+
+// newTestNetNS creates a namespace owned by the test, it only holds lo
+func newTestNetNS(t *testing.T) *NetNS {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating a net ns needs root")
+	}
+	var handle netns.NsHandle
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		origin, err := netns.Get()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer origin.Close()
+		handle, err = netns.New()
+		// A thread which cannot go back stays locked and is discarded
+		if restoreErr := netns.Set(origin); restoreErr == nil {
+			runtime.UnlockOSThread()
+		} else if err == nil {
+			err = restoreErr
+		}
+		errCh <- err
+	}()
+	if err := <-errCh; err != nil {
+		t.Fatalf("Failed to create a net ns due to %s", err.Error())
+	}
+	ns, err := newNetNS(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ns.Close() })
+	return ns
+}
+
+// routeMessage crafts a route message like the kernel sends them
+func routeMessage(msg *nl.RtMsg, attrs ...*nl.RtAttr) []byte {
+	data := msg.Serialize()
+	for _, attr := range attrs {
+		data = append(data, attr.Serialize()...)
+	}
+	return data
+}
+
+func TestMultiPathRoundTrip(t *testing.T) {
+	ns := newTestNetNS(t)
+	hops := []*NextHop{
+		{Gw: net.ParseIP("10.0.0.1").To4(), LinkName: "lo", Weight: 1},
+		{Gw: net.ParseIP("10.0.0.2").To4(), LinkName: "lo", Weight: 256, OnLink: true},
+		{LinkName: "lo", Weight: 3},
+	}
+	family := -1
+	var multiPath []byte
+	loIndex := 0
+	err := ns.Do(func() error {
+		var err error
+		multiPath, err = encodeMultiPath(hops, func(ip net.IP) error {
+			family = nl.GetIPFamily(ip)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		loIndex = lo.Attrs().Index
+		missing := []*NextHop{{Gw: net.ParseIP("10.0.0.1"), LinkName: "eth0"}}
+		if _, err = encodeMultiPath(missing, func(net.IP) error { return nil }); err == nil {
+			t.Errorf("A hop through a missing link must fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if family != netlink.FAMILY_V4 {
+		t.Errorf("got the family %d, want %d", family, netlink.FAMILY_V4)
+	}
+
+	msg := nl.NewRtMsg()
+	msg.Family = syscall.AF_INET
+	msg.Dst_len = 24
+	msg.Table = syscall.RT_TABLE_MAIN
+	m := routeMessage(msg,
+		nl.NewRtAttr(syscall.RTA_DST, net.ParseIP("192.168.0.0").To4()),
+		nl.NewRtAttr(syscall.RTA_PRIORITY, nl.Uint32Attr(10)),
+		nl.NewRtAttr(syscall.RTA_MULTIPATH, multiPath))
+	route, indexes, err := parseRoute(m, map[int]string{loIndex: "lo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{loIndex, loIndex, loIndex}; !reflect.DeepEqual(indexes, want) {
+		t.Errorf("got the indexes %v, want %v", indexes, want)
+	}
+	if route.Dst.String() != "192.168.0.0/24" || route.Metric != 10 || route.Table != 0 {
+		t.Errorf("got the route %s", route)
+	}
+	if !reflect.DeepEqual(route.MultiPath[:2], hops[:2]) {
+		t.Errorf("got the hops %+v %+v, want %+v %+v", route.MultiPath[0], route.MultiPath[1],
+			hops[0], hops[1])
+	}
+	if hop := route.MultiPath[2]; hop.Gw != nil || hop.Weight != 3 || hop.LinkName != "lo" {
+		t.Errorf("got the hop %+v, want %+v", hop, hops[2])
+	}
+}
+
+func TestParseMultiPathTruncated(t *testing.T) {
+	native := nl.NativeEndian()
+	hop := func(length int, ifindex uint32) []byte {
+		header := make([]byte, length)
+		native.PutUint16(header[0:2], uint16(length))
+		native.PutUint32(header[4:8], ifindex)
+		return header
+	}
+	tests := []struct {
+		name  string
+		value []byte
+		hops  int
+	}{
+		{"aligned", append(hop(syscall.SizeofRtNexthop, 7), hop(syscall.SizeofRtNexthop, 8)...), 2},
+		{"unpadded last hop", append(hop(syscall.SizeofRtNexthop, 7), hop(syscall.SizeofRtNexthop+2, 8)...), 2},
+		{"short hop", append(hop(syscall.SizeofRtNexthop, 7), 4, 0, 0, 0), 1},
+		{"overlong hop", append(hop(syscall.SizeofRtNexthop, 7), 64, 0, 0, 0, 0, 0, 0, 0), 1},
+	}
+	for _, test := range tests {
+		msg := nl.NewRtMsg()
+		msg.Family = syscall.AF_INET
+		m := routeMessage(msg, nl.NewRtAttr(syscall.RTA_MULTIPATH, test.value))
+		route, _, err := parseRoute(m, map[int]string{7: "eth7", 8: "eth8"})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(route.MultiPath) != test.hops {
+			t.Errorf("%s: got %d hops, want %d", test.name, len(route.MultiPath), test.hops)
+		}
+	}
+}
+
+func TestEncodeMultiPathErrors(t *testing.T) {
+	anyFamily := func(net.IP) error { return nil }
+	tests := []struct {
+		name string
+		hops []*NextHop
+	}{
+		{"nil hop", []*NextHop{nil}},
+		{"negative weight", []*NextHop{{Gw: net.ParseIP("10.0.0.1"), Weight: -1}}},
+		{"weight above 256", []*NextHop{{Gw: net.ParseIP("10.0.0.1"), Weight: 257}}},
+	}
+	for _, test := range tests {
+		if _, err := encodeMultiPath(test.hops, anyFamily); err == nil {
+			t.Errorf("%s: want an error", test.name)
+		}
+	}
+
+	family := -1
+	mixed := []*NextHop{{Gw: net.ParseIP("10.0.0.1")}, {Gw: net.ParseIP("2001:db8::1")}}
+	_, err := encodeMultiPath(mixed, func(ip net.IP) error {
+		if family != -1 && family != nl.GetIPFamily(ip) {
+			return syscall.EINVAL
+		}
+		family = nl.GetIPFamily(ip)
+		return nil
+	})
+	if err == nil {
+		t.Errorf("The hops of mixed families must fail")
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	msg := nl.NewRtMsg()
+	msg.Family = syscall.AF_INET6
+	msg.Dst_len = 0
+	msg.Table = syscall.RT_TABLE_UNSPEC
+	msg.Flags = syscall.RTNH_F_ONLINK
+	m := routeMessage(msg,
+		nl.NewRtAttr(syscall.RTA_GATEWAY, net.ParseIP("fe80::1")),
+		nl.NewRtAttr(syscall.RTA_PREFSRC, net.ParseIP("2001:db8::2")),
+		nl.NewRtAttr(syscall.RTA_OIF, nl.Uint32Attr(42)),
+		nl.NewRtAttr(syscall.RTA_TABLE, nl.Uint32Attr(1000)))
+	route, indexes, err := parseRoute(m, map[int]string{42: "eth0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Route{
+		Gw:       net.ParseIP("fe80::1"),
+		Src:      net.ParseIP("2001:db8::2"),
+		LinkName: "eth0",
+		Table:    1000,
+		Scope:    netlink.SCOPE_UNIVERSE,
+		OnLink:   true,
+	}
+	if !reflect.DeepEqual(route, want) {
+		t.Errorf("got %+v, want %+v", route, want)
+	}
+	if !reflect.DeepEqual(indexes, []int{42}) {
+		t.Errorf("got the indexes %v, want [42]", indexes)
+	}
+}
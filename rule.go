@@ -0,0 +1,271 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The range EnsureRule allocates priorities from, below the kernel's
+// main table rule at 32766
+const (
+	RulePriorityMin = 1000
+	RulePriorityMax = 32765
+)
+
+// Rule describes a policy routing rule which selects Table for the packets
+// matching all of its selectors
+type Rule struct {
+	// Priority of the rule, 0 lets EnsureRule allocate one
+	Priority int
+	Table    int
+	// Family is only needed when neither Src nor Dst is given, it
+	// defaults to netlink.FAMILY_V4
+	Family int
+	Src    *net.IPNet
+	Dst    *net.IPNet
+	// Mark and Mask select on the fwmark, they are ignored when both are 0
+	Mark    uint32
+	Mask    uint32
+	IifName string
+	OifName string
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%d: from %s to %s fwmark %#x/%#x iif %s oif %s lookup %d",
+		r.Priority, r.Src, r.Dst, r.Mark, r.Mask, r.IifName, r.OifName, r.Table)
+}
+
+// family returns the address family of the rule
+func (r *Rule) family() int {
+	switch {
+	case r.Src != nil && r.Src.IP != nil:
+		return nl.GetIPFamily(r.Src.IP)
+	case r.Dst != nil && r.Dst.IP != nil:
+		return nl.GetIPFamily(r.Dst.IP)
+	case r.Family != 0:
+		return r.Family
+	}
+	return netlink.FAMILY_V4
+}
+
+// sameSelector tells whether both rules match the same traffic into the
+// same table, the priority is not compared
+func (r *Rule) sameSelector(other *Rule) bool {
+	return r.Table == other.Table && r.family() == other.family() &&
+		equalIPNet(r.Src, other.Src) && equalIPNet(r.Dst, other.Dst) &&
+		r.Mark == other.Mark && r.fwmask() == other.fwmask() &&
+		r.IifName == other.IifName && r.OifName == other.OifName
+}
+
+// fwmask returns the mask sent to the kernel, a mark without mask is exact
+func (r *Rule) fwmask() uint32 {
+	if r.Mark != 0 && r.Mask == 0 {
+		return 0xffffffff
+	}
+	return r.Mask
+}
+
+func equalIPNet(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return netlink.Addr{IPNet: a}.Equal(netlink.Addr{IPNet: b})
+}
+
+// EnsureRule is used to add the rule unless an identical one exists in the
+// current namespace. When rule.Priority is 0 an existing rule of any
+// priority is reused, otherwise a free priority is allocated; rule.Priority
+// is updated accordingly.
+func EnsureRule(rule *Rule) error {
+	return ensureRule(nil, rule)
+}
+
+// EnsureRule is used to add the rule inside the namespace
+func (ns *NetNS) EnsureRule(rule *Rule) error {
+	return ensureRule(ns, rule)
+}
+
+// RemoveRule is used to delete the rules identical to rule from the current
+// namespace, at any priority when rule.Priority is 0. Removing a missing
+// rule is not an error.
+func RemoveRule(rule *Rule) error {
+	return removeRule(nil, rule)
+}
+
+// RemoveRule is used to delete the rules identical to rule inside the namespace
+func (ns *NetNS) RemoveRule(rule *Rule) error {
+	return removeRule(ns, rule)
+}
+
+// Rules is used to list the rules of the current namespace looking up
+// table, 0 lists the rules of all the tables
+func Rules(family, table int) ([]Rule, error) {
+	return listRules(nil, family, table)
+}
+
+// Rules is used to list the rules inside the namespace
+func (ns *NetNS) Rules(family, table int) ([]Rule, error) {
+	return listRules(ns, family, table)
+}
+
+func ensureRule(ns *NetNS, rule *Rule) error {
+	if rule == nil {
+		return fmt.Errorf("The rule cannot be nil")
+	}
+	if rule.Table <= 0 {
+		return fmt.Errorf("The table %d of the rule is not valid", rule.Table)
+	}
+	return ns.run(func() error {
+		existing, err := dumpRules(rule.family(), 0)
+		if err != nil {
+			return err
+		}
+		used := make(map[int]bool)
+		for i := range existing {
+			used[existing[i].Priority] = true
+			if !existing[i].sameSelector(rule) {
+				continue
+			}
+			if rule.Priority == 0 || rule.Priority == existing[i].Priority {
+				rule.Priority = existing[i].Priority
+				return nil
+			}
+		}
+		if rule.Priority == 0 {
+			for prio := RulePriorityMin; prio <= RulePriorityMax; prio++ {
+				if !used[prio] {
+					rule.Priority = prio
+					break
+				}
+			}
+			if rule.Priority == 0 {
+				return fmt.Errorf("Failed to allocate a priority for rule %s", rule)
+			}
+		}
+		err = execRuleRequest(rule, syscall.RTM_NEWRULE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)
+		if err != nil {
+			return fmt.Errorf("Failed to add rule %s due to %s", rule, err.Error())
+		}
+		return nil
+	})
+}
+
+func removeRule(ns *NetNS, rule *Rule) error {
+	if rule == nil {
+		return fmt.Errorf("The rule cannot be nil")
+	}
+	return ns.run(func() error {
+		existing, err := dumpRules(rule.family(), rule.Table)
+		if err != nil {
+			return err
+		}
+		for i := range existing {
+			if !existing[i].sameSelector(rule) {
+				continue
+			}
+			if rule.Priority != 0 && rule.Priority != existing[i].Priority {
+				continue
+			}
+			err = execRuleRequest(&existing[i], syscall.RTM_DELRULE, 0)
+			if err != nil && err != syscall.ENOENT {
+				return fmt.Errorf("Failed to delete rule %s due to %s", &existing[i], err.Error())
+			}
+		}
+		return nil
+	})
+}
+
+func listRules(ns *NetNS, family, table int) ([]Rule, error) {
+	var rules []Rule
+	err := ns.run(func() error {
+		var err error
+		rules, err = dumpRules(family, table)
+		return err
+	})
+	return rules, err
+}
+
+// dumpRules lists the rules with the vendored RuleList, it must run inside
+// the namespace
+func dumpRules(family, table int) ([]Rule, error) {
+	nlRules, err := netlink.RuleList(family)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list rules due to %s", err.Error())
+	}
+	var rules []Rule
+	for _, nlRule := range nlRules {
+		rule := Rule{
+			Priority: nlRule.Priority,
+			Table:    nlRule.Table,
+			Family:   int(nlRule.RtMsg.Family),
+			Src:      nlRule.Src,
+			Dst:      nlRule.Dst,
+			IifName:  nlRule.IifName,
+			OifName:  nlRule.OifName,
+		}
+		if nlRule.Mark >= 0 {
+			rule.Mark = uint32(nlRule.Mark)
+		}
+		if nlRule.Mask >= 0 {
+			rule.Mask = uint32(nlRule.Mask)
+		}
+		if table != 0 && rule.Table != table {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// execRuleRequest sends the rule request, it must run inside the namespace.
+// The vendored RuleAdd shares one buffer between all its u32 attributes,
+// which corrupts rules carrying both a priority and a fwmark.
+func execRuleRequest(rule *Rule, cmd, flags int) error {
+	msg := nl.NewRtMsg()
+	msg.Family = uint8(rule.family())
+	msg.Type = nl.FR_ACT_TO_TBL
+	msg.Table = syscall.RT_TABLE_UNSPEC
+	if rule.Table < 256 {
+		msg.Table = uint8(rule.Table)
+	}
+	var attrs []*nl.RtAttr
+	if rule.Src != nil && rule.Src.IP != nil {
+		srcLen, _ := rule.Src.Mask.Size()
+		msg.Src_len = uint8(srcLen)
+		attrs = append(attrs, nl.NewRtAttr(nl.FRA_SRC, ipData(rule.Src.IP)))
+	}
+	if rule.Dst != nil && rule.Dst.IP != nil {
+		dstLen, _ := rule.Dst.Mask.Size()
+		msg.Dst_len = uint8(dstLen)
+		attrs = append(attrs, nl.NewRtAttr(nl.FRA_DST, ipData(rule.Dst.IP)))
+	}
+	if rule.Dst != nil && rule.Dst.IP != nil && nl.GetIPFamily(rule.Dst.IP) != rule.family() {
+		return fmt.Errorf("The source and destination ip are not the same family")
+	}
+	attrs = append(attrs, nl.NewRtAttr(nl.FRA_TABLE, nl.Uint32Attr(uint32(rule.Table))))
+	if rule.Priority > 0 {
+		attrs = append(attrs, nl.NewRtAttr(nl.FRA_PRIORITY, nl.Uint32Attr(uint32(rule.Priority))))
+	}
+	if rule.Mark != 0 || rule.Mask != 0 {
+		attrs = append(attrs, nl.NewRtAttr(nl.FRA_FWMARK, nl.Uint32Attr(rule.Mark)))
+		attrs = append(attrs, nl.NewRtAttr(nl.FRA_FWMASK, nl.Uint32Attr(rule.fwmask())))
+	}
+	if rule.IifName != "" {
+		attrs = append(attrs, nl.NewRtAttr(nl.FRA_IIFNAME, nl.ZeroTerminated(rule.IifName)))
+	}
+	if rule.OifName != "" {
+		attrs = append(attrs, nl.NewRtAttr(nl.FRA_OIFNAME, nl.ZeroTerminated(rule.OifName)))
+	}
+
+	req := nl.NewNetlinkRequest(cmd, flags|syscall.NLM_F_ACK)
+	req.AddData(msg)
+	for _, attr := range attrs {
+		req.AddData(attr)
+	}
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
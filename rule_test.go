@@ -0,0 +1,66 @@
+package gonet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestRuleFwmask(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want uint32
+	}{
+		{Rule{}, 0},
+		{Rule{Mark: 0x10}, 0xffffffff},
+		{Rule{Mark: 0x10, Mask: 0xf0}, 0xf0},
+		{Rule{Mask: 0xf0}, 0xf0},
+	}
+	for _, test := range tests {
+		if got := test.rule.fwmask(); got != test.want {
+			t.Errorf("%s: got %#x, want %#x", test.rule, got, test.want)
+		}
+	}
+}
+
+func TestRuleSameSelector(t *testing.T) {
+	_, src, _ := net.ParseCIDR("10.0.0.0/24")
+	src16 := &net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(24, 32)}
+	_, src6, _ := net.ParseCIDR("2001:db8::/64")
+	base := Rule{Priority: 1000, Table: 100, Src: src, Mark: 0x1, IifName: "eth0"}
+	tests := []struct {
+		name  string
+		other Rule
+		same  bool
+	}{
+		{"identical", base, true},
+		{"other priority", Rule{Priority: 2000, Table: 100, Src: src, Mark: 0x1, IifName: "eth0"}, true},
+		{"16 bytes source", Rule{Table: 100, Src: src16, Mark: 0x1, IifName: "eth0"}, true},
+		{"exact mask", Rule{Table: 100, Src: src, Mark: 0x1, Mask: 0xffffffff, IifName: "eth0"}, true},
+		{"other mask", Rule{Table: 100, Src: src, Mark: 0x1, Mask: 0xff, IifName: "eth0"}, false},
+		{"other table", Rule{Table: 101, Src: src, Mark: 0x1, IifName: "eth0"}, false},
+		{"no source", Rule{Table: 100, Mark: 0x1, IifName: "eth0"}, false},
+		{"other iif", Rule{Table: 100, Src: src, Mark: 0x1, IifName: "eth1"}, false},
+		{"oif", Rule{Table: 100, Src: src, Mark: 0x1, IifName: "eth0", OifName: "eth1"}, false},
+		{"other mark", Rule{Table: 100, Src: src, Mark: 0x2, IifName: "eth0"}, false},
+		{"ipv6 source", Rule{Table: 100, Src: src6, Mark: 0x1, IifName: "eth0"}, false},
+	}
+	for _, test := range tests {
+		if got := base.sameSelector(&test.other); got != test.same {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.same)
+		}
+		if got := test.other.sameSelector(&base); got != test.same {
+			t.Errorf("%s reversed: got %v, want %v", test.name, got, test.same)
+		}
+	}
+
+	v4 := Rule{Table: 100}
+	v6 := Rule{Table: 100, Family: netlink.FAMILY_V6}
+	if v4.sameSelector(&v6) {
+		t.Errorf("The rules of different families must differ")
+	}
+	if !v4.sameSelector(&Rule{Table: 100, Family: netlink.FAMILY_V4}) {
+		t.Errorf("The family must default to ipv4")
+	}
+}
@@ -0,0 +1,563 @@
+package gonet
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// The handles of the qdiscs gonet manages at the root of a link
+const (
+	shapingMajor   = 1
+	htbFilterPrio  = 1
+	tbfLatencyUsec = 50000
+	// defaultMTU is used to size the default bursts
+	defaultMTU = 1600
+)
+
+// rateUnits are the multipliers to bits per second of the tc rate units, a
+// rate without unit is in bits per second like tc does
+var rateUnits = map[string]float64{
+	"": 1, "bit": 1, "kbit": 1e3, "mbit": 1e6, "gbit": 1e9, "tbit": 1e12,
+	"kibit": 1 << 10, "mibit": 1 << 20, "gibit": 1 << 30, "tibit": 1 << 40,
+	"bps": 8, "kbps": 8e3, "mbps": 8e6, "gbps": 8e9, "tbps": 8e12,
+	"kibps": 8 << 10, "mibps": 8 << 20, "gibps": 8 << 30, "tibps": 8 << 40,
+}
+
+// sizeUnits are the multipliers to bytes of the tc size units
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1, "k": 1 << 10, "kb": 1 << 10, "m": 1 << 20, "mb": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "kbit": 1 << 7, "mbit": 1 << 17, "gbit": 1 << 27,
+}
+
+// ParseRate is used to convert a tc rate such as "100mbit" or "10mbps" to
+// bits per second
+func ParseRate(rate string) (uint64, error) {
+	value, err := parseUnit(rate, rateUnits)
+	if err != nil {
+		return 0, fmt.Errorf("The rate %s is not valid", rate)
+	}
+	return uint64(value), nil
+}
+
+// ParseSize is used to convert a tc size such as "32kb" or "1mbit" to bytes
+func ParseSize(size string) (uint32, error) {
+	value, err := parseUnit(size, sizeUnits)
+	if err != nil || value > math.MaxUint32 {
+		return 0, fmt.Errorf("The size %s is not valid", size)
+	}
+	return uint32(value), nil
+}
+
+func parseUnit(s string, units map[string]float64) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("The number of %s is not valid", s)
+	}
+	multiplier, ok := units[s[i:]]
+	if !ok {
+		return 0, fmt.Errorf("The unit of %s is not valid", s)
+	}
+	return value * multiplier, nil
+}
+
+// SetEgressRate is used to limit the rate the link sends at with a token
+// bucket filter at the root of the link, replacing the existing root qdisc.
+// The rate and burst use the tc units, an empty burst picks one from the
+// rate. Setting the same rate again changes the filter in place.
+func (lnk *linuxLink) SetEgressRate(rate, burst string) error {
+	bits, err := ParseRate(rate)
+	if err != nil {
+		return err
+	}
+	bytesRate := bits / 8
+	if bytesRate == 0 || bytesRate > math.MaxUint32 {
+		return fmt.Errorf("The rate %s is out of range", rate)
+	}
+	size := uint32(float64(bytesRate)/netlink.Hz()) + defaultMTU
+	if burst != "" {
+		if size, err = ParseSize(burst); err != nil {
+			return err
+		}
+	}
+	limit := float64(bytesRate)*tbfLatencyUsec/1e6 + float64(size)
+	return lnk.exec(func() error {
+		netem, err := netemQdisc(lnk.link)
+		if err != nil {
+			return err
+		}
+		// An htb tree holds the handle of the filter, it goes with its classes
+		deleted, err := delShapingQdisc(lnk.link, "tbf")
+		if err != nil {
+			return err
+		}
+		err = netlink.QdiscReplace(&netlink.Tbf{
+			QdiscAttrs: netlink.QdiscAttrs{
+				LinkIndex: lnk.link.Attrs().Index,
+				Handle:    netlink.MakeHandle(shapingMajor, 0),
+				Parent:    netlink.HANDLE_ROOT,
+			},
+			Rate:   bytesRate,
+			Limit:  uint32(math.Min(limit, math.MaxUint32)),
+			Buffer: uint32(netlink.Xmittime(bytesRate, size)),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to set egress rate of link %s due to %s",
+				lnk.link.Attrs().Name, err.Error())
+		}
+		// A netem qdisc at the root or under the htb tree was replaced, it
+		// moves under the filter
+		under := netlink.MakeHandle(shapingMajor, 1)
+		if netem != nil && (deleted || netem.Parent != under) {
+			return regraftNetem(lnk.link, netem, under)
+		}
+		return nil
+	})
+}
+
+// ClearEgressRate is used to remove the token bucket filter set by
+// SetEgressRate, it does nothing when there is none. A netem qdisc under the
+// filter moves to the root.
+func (lnk *linuxLink) ClearEgressRate() error {
+	return lnk.exec(func() error {
+		netem, err := netemQdisc(lnk.link)
+		if err != nil {
+			return err
+		}
+		deleted, err := delRootQdisc(lnk.link, "tbf")
+		if err != nil {
+			return err
+		}
+		if deleted && netem != nil && netem.Parent != netlink.HANDLE_ROOT {
+			return regraftNetem(lnk.link, netem, netlink.HANDLE_ROOT)
+		}
+		return nil
+	})
+}
+
+// rootQdisc returns the root qdisc of the link or nil, it must run in the
+// link's namespace
+func rootQdisc(link netlink.Link) (netlink.Qdisc, error) {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list qdiscs of link %s due to %s",
+			link.Attrs().Name, err.Error())
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent == netlink.HANDLE_ROOT {
+			return qdisc, nil
+		}
+	}
+	return nil, nil
+}
+
+// delRootQdisc deletes the root qdisc when it is of the given kind, the
+// kernel then restores the default one. It must run in the link's namespace.
+func delRootQdisc(link netlink.Link, kind string) (bool, error) {
+	qdisc, err := rootQdisc(link)
+	if err != nil || qdisc == nil || qdisc.Type() != kind {
+		return false, err
+	}
+	if err = netlink.QdiscDel(qdisc); err != nil {
+		return false, fmt.Errorf("Failed to delete %s qdisc of link %s due to %s",
+			kind, link.Attrs().Name, err.Error())
+	}
+	return true, nil
+}
+
+// delShapingQdisc deletes the root qdisc when it holds the handle shared by
+// the rate limiter and the htb tree but is not of the given kind, since the
+// kernel refuses to change the kind of a qdisc in place. It must run in the
+// link's namespace.
+func delShapingQdisc(link netlink.Link, kind string) (bool, error) {
+	qdisc, err := rootQdisc(link)
+	if err != nil || qdisc == nil || qdisc.Type() == kind ||
+		qdisc.Attrs().Handle != netlink.MakeHandle(shapingMajor, 0) {
+		return false, err
+	}
+	if err = netlink.QdiscDel(qdisc); err != nil {
+		return false, fmt.Errorf("Failed to delete %s qdisc of link %s due to %s",
+			qdisc.Type(), link.Attrs().Name, err.Error())
+	}
+	return true, nil
+}
+
+// HTBClass is a class of an HTB tree, its class id is 1:ID
+type HTBClass struct {
+	ID uint16
+	// Parent is the ID of the parent class, 0 for the root
+	Parent uint16
+	// Rate is the guaranteed rate and Ceil the borrowing limit, both in bits
+	// per second. Ceil defaults to Rate.
+	Rate uint64
+	Ceil uint64
+	// Burst and Cburst are in bytes, 0 picks them from the rates
+	Burst  uint32
+	Cburst uint32
+	// Prio orders the borrowing of the spare bandwidth, lower first
+	Prio uint32
+	// Marks are the firewall marks of the packets sent to the class
+	Marks []uint32
+}
+
+// HTBTree is an HTB qdisc at the root of a link with its classes
+type HTBTree struct {
+	// DefaultClass is the ID of the class of the unmatched packets, 0 sends
+	// them unshaped
+	DefaultClass uint16
+	Classes      []HTBClass
+}
+
+// ApplyHTBTree is used to make the HTB tree of the link match tree. The root
+// qdisc is replaced unless it already is the gonet HTB qdisc, in which case
+// the classes and mark filters are added, changed or removed in place. A
+// netem qdisc set by ApplyNetem moves under the default class, which must
+// then be a leaf.
+func ApplyHTBTree(link LinuxLink, tree *HTBTree) error {
+	lnk, err := asLinuxLink(link)
+	if err != nil {
+		return err
+	}
+	classes, err := tree.sorted()
+	if err != nil {
+		return err
+	}
+	return lnk.exec(func() error {
+		return applyHTBTree(lnk.link, tree, classes)
+	})
+}
+
+// ReadHTBTree is used to read back the HTB tree of the link, it returns nil
+// when the root qdisc of the link is not the gonet HTB qdisc
+func ReadHTBTree(link LinuxLink) (*HTBTree, error) {
+	lnk, err := asLinuxLink(link)
+	if err != nil {
+		return nil, err
+	}
+	var tree *HTBTree
+	err = lnk.exec(func() error {
+		var err error
+		tree, err = readHTBTree(lnk.link)
+		return err
+	})
+	return tree, err
+}
+
+// sorted validates the tree and returns its classes with the parents first
+func (tree *HTBTree) sorted() ([]HTBClass, error) {
+	if tree == nil {
+		return nil, fmt.Errorf("The htb tree cannot be nil")
+	}
+	byID := make(map[uint16]*HTBClass)
+	for i := range tree.Classes {
+		class := &tree.Classes[i]
+		if class.ID == 0 {
+			return nil, fmt.Errorf("The id of htb class cannot be 0")
+		}
+		if byID[class.ID] != nil {
+			return nil, fmt.Errorf("The htb class id %d is not unique", class.ID)
+		}
+		if class.Rate == 0 || class.Rate/8 > math.MaxUint32 || class.Ceil/8 > math.MaxUint32 {
+			return nil, fmt.Errorf("The rate of htb class %d is out of range", class.ID)
+		}
+		if class.Ceil != 0 && class.Ceil < class.Rate {
+			return nil, fmt.Errorf("The ceil of htb class %d is below its rate", class.ID)
+		}
+		byID[class.ID] = class
+	}
+	if tree.DefaultClass != 0 && byID[tree.DefaultClass] == nil {
+		return nil, fmt.Errorf("The default htb class %d does not exist", tree.DefaultClass)
+	}
+	depth := make(map[uint16]int)
+	for id := range byID {
+		seen := make(map[uint16]bool)
+		for cur := byID[id]; cur.Parent != 0; cur = byID[cur.Parent] {
+			if byID[cur.Parent] == nil {
+				return nil, fmt.Errorf("The parent %d of htb class %d does not exist", cur.Parent, cur.ID)
+			}
+			if seen[cur.ID] {
+				return nil, fmt.Errorf("The htb class %d is its own ancestor", id)
+			}
+			seen[cur.ID] = true
+			depth[id]++
+		}
+	}
+	classes := append([]HTBClass(nil), tree.Classes...)
+	sort.SliceStable(classes, func(i, j int) bool {
+		return depth[classes[i].ID] < depth[classes[j].ID]
+	})
+	return classes, nil
+}
+
+// applyHTBTree must run in the link's namespace
+func applyHTBTree(link netlink.Link, tree *HTBTree, classes []HTBClass) error {
+	name := link.Attrs().Name
+	root := netlink.MakeHandle(shapingMajor, 0)
+	parents := make(map[uint32]uint32)
+	for _, class := range classes {
+		parent := root
+		if class.Parent != 0 {
+			parent = netlink.MakeHandle(shapingMajor, class.Parent)
+		}
+		parents[netlink.MakeHandle(shapingMajor, class.ID)] = parent
+	}
+	netem, err := netemQdisc(link)
+	if err != nil {
+		return err
+	}
+	defaultClass := netlink.MakeHandle(shapingMajor, tree.DefaultClass)
+	if netem != nil {
+		if tree.DefaultClass == 0 {
+			return fmt.Errorf("The netem qdisc of link %s needs a default htb class", name)
+		}
+		for _, parent := range parents {
+			if parent == defaultClass {
+				return fmt.Errorf("The default htb class %d of link %s has children, "+
+					"it cannot hold the netem qdisc", tree.DefaultClass, name)
+			}
+		}
+	}
+
+	// A rate limiter holds the handle of the htb qdisc
+	if _, err = delShapingQdisc(link, "htb"); err != nil {
+		return err
+	}
+	qdisc, err := rootQdisc(link)
+	if err != nil {
+		return err
+	}
+	// The htb qdisc cannot be changed in place, neither can the parent of
+	// a class, the tree is rebuilt from scratch when either differs
+	current, _ := qdisc.(*netlink.Htb)
+	rebuild := current == nil || current.Handle != root || current.Defcls != uint32(tree.DefaultClass)
+	var stale []netlink.Class
+	if !rebuild {
+		existing, err := netlink.ClassList(link, root)
+		if err != nil {
+			return fmt.Errorf("Failed to list htb classes of link %s due to %s", name, err.Error())
+		}
+		for _, class := range existing {
+			parent, ok := parents[class.Attrs().Handle]
+			if !ok {
+				stale = append(stale, class)
+			} else if parent != classParent(class) {
+				rebuild = true
+			}
+		}
+	}
+	if rebuild {
+		if current != nil && current.Handle == root {
+			if err = netlink.QdiscDel(current); err != nil {
+				return fmt.Errorf("Failed to delete htb qdisc of link %s due to %s", name, err.Error())
+			}
+		}
+		htb := netlink.NewHtb(netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    root,
+			Parent:    netlink.HANDLE_ROOT,
+		})
+		htb.Defcls = uint32(tree.DefaultClass)
+		if err = netlink.QdiscReplace(htb); err != nil {
+			return fmt.Errorf("Failed to set htb qdisc of link %s due to %s", name, err.Error())
+		}
+		stale = nil
+	}
+
+	for _, class := range classes {
+		handle := netlink.MakeHandle(shapingMajor, class.ID)
+		htbClass := netlink.NewHtbClass(netlink.ClassAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    handle,
+			Parent:    parents[handle],
+		}, netlink.HtbClassAttrs{
+			Rate:    class.Rate,
+			Ceil:    class.Ceil,
+			Buffer:  class.Burst,
+			Cbuffer: class.Cburst,
+		})
+		// Let the kernel derive the quantum from the rate
+		htbClass.Quantum = 0
+		htbClass.Prio = class.Prio
+		if err = netlink.ClassReplace(htbClass); err != nil {
+			return fmt.Errorf("Failed to set htb class %d of link %s due to %s",
+				class.ID, name, err.Error())
+		}
+	}
+	if err = applyHTBFilters(link, classes); err != nil {
+		return err
+	}
+	// The netem qdisc went with the replaced root qdisc or sits under the
+	// former default class
+	if netem != nil && (rebuild || netem.Parent != defaultClass) {
+		if err = regraftNetem(link, netem, defaultClass); err != nil {
+			return err
+		}
+	}
+
+	// The kernel refuses to delete a class with children or
+	// filters, go leaves first once the filters are in sync
+	sort.SliceStable(stale, func(i, j int) bool {
+		return classDepth(stale, stale[i]) > classDepth(stale, stale[j])
+	})
+	for _, class := range stale {
+		if err = netlink.ClassDel(class); err != nil {
+			return fmt.Errorf("Failed to delete htb class %s of link %s due to %s",
+				netlink.HandleStr(class.Attrs().Handle), name, err.Error())
+		}
+	}
+	return nil
+}
+
+// classParent returns the parent of the class, the kernel reports the
+// classes at the top of the tree under the root handle instead of the qdisc
+func classParent(class netlink.Class) uint32 {
+	if class.Attrs().Parent == netlink.HANDLE_ROOT {
+		return netlink.MakeHandle(shapingMajor, 0)
+	}
+	return class.Attrs().Parent
+}
+
+// classDepth counts the ancestors of the class found among classes
+func classDepth(classes []netlink.Class, class netlink.Class) int {
+	depth := 0
+	for parent := class.Attrs().Parent; ; depth++ {
+		found := false
+		for _, other := range classes {
+			if other.Attrs().Handle == parent {
+				parent, found = other.Attrs().Parent, true
+				break
+			}
+		}
+		if !found {
+			return depth
+		}
+	}
+}
+
+// applyHTBFilters makes the fw filters of the root qdisc match the marks of
+// the classes, it must run in the link's namespace
+func applyHTBFilters(link netlink.Link, classes []HTBClass) error {
+	root := netlink.MakeHandle(shapingMajor, 0)
+	wanted := make(map[uint32]uint32)
+	for _, class := range classes {
+		for _, mark := range class.Marks {
+			wanted[mark] = netlink.MakeHandle(shapingMajor, class.ID)
+		}
+	}
+	filters, err := netlink.FilterList(link, root)
+	if err != nil {
+		return fmt.Errorf("Failed to list filters of link %s due to %s",
+			link.Attrs().Name, err.Error())
+	}
+	for _, filter := range filters {
+		fw, ok := filter.(*netlink.Fw)
+		if !ok || fw.Priority != htbFilterPrio {
+			continue
+		}
+		if classID, ok := wanted[fw.Handle]; ok && classID == fw.ClassId {
+			delete(wanted, fw.Handle)
+			continue
+		}
+		if err = netlink.FilterDel(fw); err != nil {
+			return fmt.Errorf("Failed to delete filter of mark %d of link %s due to %s",
+				fw.Handle, link.Attrs().Name, err.Error())
+		}
+	}
+	for mark, classID := range wanted {
+		err = netlink.FilterAdd(&netlink.Fw{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: link.Attrs().Index,
+				Parent:    root,
+				Handle:    mark,
+				Priority:  htbFilterPrio,
+				Protocol:  syscall.ETH_P_ALL,
+			},
+			ClassId: classID,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to add filter of mark %d of link %s due to %s",
+				mark, link.Attrs().Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// readHTBTree must run in the link's namespace
+func readHTBTree(link netlink.Link) (*HTBTree, error) {
+	name := link.Attrs().Name
+	root := netlink.MakeHandle(shapingMajor, 0)
+	qdisc, err := rootQdisc(link)
+	if err != nil {
+		return nil, err
+	}
+	htb, ok := qdisc.(*netlink.Htb)
+	if !ok || htb.Handle != root {
+		return nil, nil
+	}
+	tree := &HTBTree{DefaultClass: uint16(htb.Defcls)}
+	classes, err := netlink.ClassList(link, root)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list htb classes of link %s due to %s", name, err.Error())
+	}
+	index := make(map[uint32]int)
+	for _, class := range classes {
+		htbClass, ok := class.(*netlink.HtbClass)
+		if !ok {
+			continue
+		}
+		major, minor := netlink.MajorMinor(htbClass.Handle)
+		if major != shapingMajor {
+			continue
+		}
+		var parent uint16
+		if classParent(htbClass) != root {
+			_, parent = netlink.MajorMinor(htbClass.Parent)
+		}
+		index[htbClass.Handle] = len(tree.Classes)
+		tree.Classes = append(tree.Classes, HTBClass{
+			ID:     minor,
+			Parent: parent,
+			Rate:   htbClass.Rate * 8,
+			Ceil:   htbClass.Ceil * 8,
+			Burst:  tickBytes(htbClass.Rate, htbClass.Buffer),
+			Cburst: tickBytes(htbClass.Ceil, htbClass.Cbuffer),
+			Prio:   htbClass.Prio,
+		})
+	}
+	filters, err := netlink.FilterList(link, root)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list filters of link %s due to %s", name, err.Error())
+	}
+	for _, filter := range filters {
+		fw, ok := filter.(*netlink.Fw)
+		if !ok || fw.Priority != htbFilterPrio {
+			continue
+		}
+		if i, ok := index[fw.ClassId]; ok {
+			tree.Classes[i].Marks = append(tree.Classes[i].Marks, fw.Handle)
+		}
+	}
+	sort.Slice(tree.Classes, func(i, j int) bool {
+		return tree.Classes[i].ID < tree.Classes[j].ID
+	})
+	return tree, nil
+}
+
+// tickBytes converts a buffer in ticks back to bytes at the rate in bytes
+// per second
+func tickBytes(rate uint64, ticks uint32) uint32 {
+	usec := float64(ticks) / netlink.TickInUsec()
+	return uint32(float64(rate) * usec / 1e6)
+}
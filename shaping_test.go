@@ -0,0 +1,115 @@
+package gonet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate string
+		want uint64
+		fail bool
+	}{
+		{rate: "100mbit", want: 100e6},
+		{rate: "10mbps", want: 80e6},
+		{rate: "1.5gbit", want: 1.5e9},
+		{rate: "1kibit", want: 1024},
+		{rate: " 8Kbit ", want: 8000},
+		{rate: "125", want: 125},
+		{rate: "0bit", want: 0},
+		{rate: "", fail: true},
+		{rate: "mbit", fail: true},
+		{rate: "-1mbit", fail: true},
+		{rate: "10xbit", fail: true},
+		{rate: "1.2.3mbit", fail: true},
+	}
+	for _, test := range tests {
+		got, err := ParseRate(test.rate)
+		if test.fail {
+			if err == nil {
+				t.Errorf("ParseRate(%q) = %d, want an error", test.rate, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseRate(%q) = %d, %v, want %d", test.rate, got, err, test.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want uint32
+		fail bool
+	}{
+		{size: "1500", want: 1500},
+		{size: "1500b", want: 1500},
+		{size: "32kb", want: 32 << 10},
+		{size: "32K", want: 32 << 10},
+		{size: "2mb", want: 2 << 20},
+		{size: "1mbit", want: 1 << 17},
+		{size: "3gb", want: 3 << 30},
+		{size: "5gb", fail: true},
+		{size: "", fail: true},
+		{size: "10kbps", fail: true},
+	}
+	for _, test := range tests {
+		got, err := ParseSize(test.size)
+		if test.fail {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want an error", test.size, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", test.size, got, err, test.want)
+		}
+	}
+}
+
+func TestHTBTreeSorted(t *testing.T) {
+	tree := &HTBTree{DefaultClass: 30, Classes: []HTBClass{
+		{ID: 30, Parent: 20, Rate: 1e6},
+		{ID: 20, Parent: 10, Rate: 2e6},
+		{ID: 11, Parent: 10, Rate: 3e6, Ceil: 5e6},
+		{ID: 10, Rate: 10e6},
+	}}
+	classes, err := tree.sorted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint16
+	for _, class := range classes {
+		ids = append(ids, class.ID)
+	}
+	if want := []uint16{10, 20, 11, 30}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got the order %v, want %v", ids, want)
+	}
+	if tree.Classes[0].ID != 30 {
+		t.Errorf("sorted reordered the classes of the tree")
+	}
+
+	tests := []struct {
+		name string
+		tree *HTBTree
+	}{
+		{"nil tree", nil},
+		{"zero id", &HTBTree{Classes: []HTBClass{{ID: 0, Rate: 1e6}}}},
+		{"duplicate id", &HTBTree{Classes: []HTBClass{{ID: 1, Rate: 1e6}, {ID: 1, Rate: 2e6}}}},
+		{"zero rate", &HTBTree{Classes: []HTBClass{{ID: 1}}}},
+		{"rate out of range", &HTBTree{Classes: []HTBClass{{ID: 1, Rate: 1 << 40}}}},
+		{"ceil below rate", &HTBTree{Classes: []HTBClass{{ID: 1, Rate: 2e6, Ceil: 1e6}}}},
+		{"missing default", &HTBTree{DefaultClass: 2, Classes: []HTBClass{{ID: 1, Rate: 1e6}}}},
+		{"missing parent", &HTBTree{Classes: []HTBClass{{ID: 1, Parent: 2, Rate: 1e6}}}},
+		{"cycle", &HTBTree{Classes: []HTBClass{
+			{ID: 1, Parent: 2, Rate: 1e6}, {ID: 2, Parent: 1, Rate: 1e6}}}},
+		{"self parent", &HTBTree{Classes: []HTBClass{{ID: 1, Parent: 1, Rate: 1e6}}}},
+	}
+	for _, test := range tests {
+		if _, err := test.tree.sorted(); err == nil {
+			t.Errorf("%s: want an error", test.name)
+		}
+	}
+}
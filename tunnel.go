@@ -0,0 +1,139 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The IFLA_IPTUN attributes of the ipip and sit IFLA_INFO_DATA, the vendored
+// nl package does not define them
+const (
+	iflaIptunLink     = 1
+	iflaIptunLocal    = 2
+	iflaIptunRemote   = 3
+	iflaIptunTTL      = 4
+	iflaIptunTOS      = 5
+	iflaIptunPmtudisc = 10
+)
+
+// greKeyFlag is GRE_KEY of IFLA_GRE_IFLAGS and IFLA_GRE_OFLAGS
+const greKeyFlag = 0x2000
+
+// TunnelKind is the kind of an IPv4 point to point tunnel
+type TunnelKind string
+
+// The supported tunnel kinds
+const (
+	TunnelGRE    TunnelKind = "gre"
+	TunnelGRETap TunnelKind = "gretap"
+	TunnelIPIP   TunnelKind = "ipip"
+	TunnelSIT    TunnelKind = "sit"
+)
+
+// TunnelConfig describes a tunnel, all the endpoints are IPv4 addresses
+type TunnelConfig struct {
+	Name string
+	// Local and Remote are the outer addresses, nil matches any
+	Local  net.IP
+	Remote net.IP
+	// Dev is the underlay link the tunnel is bound to
+	Dev LinuxLink
+	// IKey and OKey are the keys of the received and sent GRE packets,
+	// 0 means no key, they are only valid for gre and gretap
+	IKey uint32
+	OKey uint32
+	// TTL of the outer header, 0 inherits the inner one
+	TTL int
+	TOS int
+	// NoPMTUDisc turns off path MTU discovery, it needs an inherited TTL
+	NoPMTUDisc bool
+}
+
+// NewTunnel is used to create a tunnel of the given kind
+func NewTunnel(kind TunnelKind, config TunnelConfig) (LinuxLink, error) {
+	return newTunnel(nil, kind, config)
+}
+
+// NewTunnel is used to create a tunnel inside the namespace
+func (ns *NetNS) NewTunnel(kind TunnelKind, config TunnelConfig) (LinuxLink, error) {
+	return newTunnel(ns, kind, config)
+}
+
+func newTunnel(ns *NetNS, kind TunnelKind, config TunnelConfig) (LinuxLink, error) {
+	isGre := kind == TunnelGRE || kind == TunnelGRETap
+	if !isGre && kind != TunnelIPIP && kind != TunnelSIT {
+		return nil, fmt.Errorf("The tunnel kind %s is not valid", kind)
+	}
+	if !isGre && (config.IKey != 0 || config.OKey != 0) {
+		return nil, fmt.Errorf("The %s tunnel does not support keys", kind)
+	}
+	for _, ip := range []net.IP{config.Local, config.Remote} {
+		if ip != nil && ip.To4() == nil {
+			return nil, fmt.Errorf("The tunnel endpoint %s is not an IPv4 address", ip)
+		}
+	}
+	if config.TTL < 0 || config.TTL > 255 {
+		return nil, fmt.Errorf("The ttl %d of the tunnel is not valid", config.TTL)
+	}
+	if config.NoPMTUDisc && config.TTL != 0 {
+		return nil, fmt.Errorf("The path mtu discovery of the tunnel cannot be off with a fixed ttl")
+	}
+	devIndex := 0
+	if config.Dev != nil {
+		dev, err := asLinuxLink(config.Dev)
+		if err != nil {
+			return nil, err
+		}
+		same, err := sameNetNS(dev.ns, ns)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			return nil, fmt.Errorf("The link %s is not in the net ns of the tunnel", dev.link.Attrs().Name)
+		}
+		devIndex = dev.link.Attrs().Index
+	}
+	pmtuDisc := uint8(1)
+	if config.NoPMTUDisc {
+		pmtuDisc = 0
+	}
+
+	fillData := func(data *nl.RtAttr) {
+		attrLink, attrLocal, attrRemote := iflaIptunLink, iflaIptunLocal, iflaIptunRemote
+		attrTTL, attrTOS, attrPmtudisc := iflaIptunTTL, iflaIptunTOS, iflaIptunPmtudisc
+		if isGre {
+			attrLink, attrLocal, attrRemote = nl.IFLA_GRE_LINK, nl.IFLA_GRE_LOCAL, nl.IFLA_GRE_REMOTE
+			attrTTL, attrTOS, attrPmtudisc = nl.IFLA_GRE_TTL, nl.IFLA_GRE_TOS, nl.IFLA_GRE_PMTUDISC
+			addGreKey(data, nl.IFLA_GRE_IFLAGS, nl.IFLA_GRE_IKEY, config.IKey)
+			addGreKey(data, nl.IFLA_GRE_OFLAGS, nl.IFLA_GRE_OKEY, config.OKey)
+		}
+		if devIndex != 0 {
+			nl.NewRtAttrChild(data, attrLink, nl.Uint32Attr(uint32(devIndex)))
+		}
+		if config.Local != nil {
+			nl.NewRtAttrChild(data, attrLocal, []byte(config.Local.To4()))
+		}
+		if config.Remote != nil {
+			nl.NewRtAttrChild(data, attrRemote, []byte(config.Remote.To4()))
+		}
+		nl.NewRtAttrChild(data, attrTTL, nl.Uint8Attr(uint8(config.TTL)))
+		nl.NewRtAttrChild(data, attrTOS, nl.Uint8Attr(uint8(config.TOS)))
+		nl.NewRtAttrChild(data, attrPmtudisc, nl.Uint8Attr(pmtuDisc))
+	}
+	lnk, err := addLinuxLinkInfo(ns, config.Name, string(kind), 0, fillData)
+	if err != nil {
+		return nil, err
+	}
+	return lnk, nil
+}
+
+// addGreKey adds the key and its flag, both in network byte order
+func addGreKey(data *nl.RtAttr, flagsAttr, keyAttr int, key uint32) {
+	if key == 0 {
+		return
+	}
+	nl.NewRtAttrChild(data, flagsAttr, htons(greKeyFlag))
+	nl.NewRtAttrChild(data, keyAttr, []byte{byte(key >> 24), byte(key >> 16), byte(key >> 8), byte(key)})
+}
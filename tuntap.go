@@ -0,0 +1,209 @@
+package gonet
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/vishvananda/netlink"
+)
+
+// The tun ioctls and flags missing from the syscall package
+const (
+	tunSetPersist = 0x400454cb
+	tunSetOwner   = 0x400454cc
+	tunSetGroup   = 0x400454ce
+	iffMultiQueue = 0x0100
+)
+
+const (
+	tunDevicePath = "/dev/net/tun"
+	// tunIfreqPadLen pads ifreq to the size of struct ifreq
+	tunIfreqPadLen = 40 - syscall.IFNAMSIZ - 2
+)
+
+// TuntapConfig describes a tun or tap device
+type TuntapConfig struct {
+	// Name of the device, empty lets the kernel pick one. The device must
+	// not exist yet.
+	Name string
+	// Mode is either netlink.TUNTAP_MODE_TUN or netlink.TUNTAP_MODE_TAP
+	Mode netlink.TuntapMode
+	// Queues is the number of queues opened, more than one needs MultiQueue
+	Queues     int
+	MultiQueue bool
+	// Persist keeps the device once all its queues are closed
+	Persist bool
+	// Owner and Group are the uid and gid allowed to attach to the device,
+	// nil leaves them unset
+	Owner *int
+	Group *int
+	// VnetHdr prepends a virtio net header to the packets
+	VnetHdr bool
+	// PacketInfo prepends the packet information header to the packets
+	PacketInfo bool
+}
+
+// ifreq is the struct ifreq of the tun ioctls
+type ifreq struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	pad   [tunIfreqPadLen]byte
+}
+
+// NewTuntap is used to create a tun or tap device, it returns the link and
+// the opened queues. A device which is not persistent is removed by the
+// kernel once all its queues are closed.
+func NewTuntap(config TuntapConfig) (LinuxLink, []*os.File, error) {
+	return newTuntap(nil, config)
+}
+
+// NewTuntap is used to create a tun or tap device inside the namespace
+func (ns *NetNS) NewTuntap(config TuntapConfig) (LinuxLink, []*os.File, error) {
+	return newTuntap(ns, config)
+}
+
+func newTuntap(ns *NetNS, config TuntapConfig) (LinuxLink, []*os.File, error) {
+	if config.Mode != netlink.TUNTAP_MODE_TUN && config.Mode != netlink.TUNTAP_MODE_TAP {
+		return nil, nil, fmt.Errorf("The tuntap mode %d is not valid", config.Mode)
+	}
+	if len(config.Name) >= syscall.IFNAMSIZ {
+		return nil, nil, fmt.Errorf("The tuntap name %s is too long", config.Name)
+	}
+	queues := config.Queues
+	if queues <= 0 {
+		queues = 1
+	}
+	if queues > 1 && !config.MultiQueue {
+		return nil, nil, fmt.Errorf("The tuntap %s needs multi queue for %d queues", config.Name, queues)
+	}
+	if config.Owner != nil && *config.Owner < 0 {
+		return nil, nil, fmt.Errorf("The tuntap owner %d is not valid", *config.Owner)
+	}
+	if config.Group != nil && *config.Group < 0 {
+		return nil, nil, fmt.Errorf("The tuntap group %d is not valid", *config.Group)
+	}
+	flags := uint16(config.Mode)
+	if config.MultiQueue {
+		flags |= iffMultiQueue
+	}
+	if config.VnetHdr {
+		flags |= syscall.IFF_VNET_HDR
+	}
+	if !config.PacketInfo {
+		flags |= syscall.IFF_NO_PI
+	}
+
+	var fds []int
+	closeFds := func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}
+	name := config.Name
+	// created is only set once the first queue made the device, a device
+	// this call did not create is never deleted on failure
+	created := false
+	deleteCreated := func() {
+		if created && config.Persist {
+			deleteLink(ns, name)
+		}
+	}
+	// The device is created in the namespace of the thread attaching the
+	// first queue. TUNSETIFF attaches to an existing device of the same
+	// name, so it is rejected first.
+	err := ns.run(func() error {
+		if name != "" {
+			if _, err := netlink.LinkByName(name); err == nil {
+				return fmt.Errorf("The link %s already exists", name)
+			}
+		}
+		for i := 0; i < queues; i++ {
+			fd, ifName, err := openTunQueue(name, flags)
+			if err != nil {
+				return err
+			}
+			fds = append(fds, fd)
+			name = ifName
+			created = true
+		}
+		fd := uintptr(fds[0])
+		if config.Owner != nil {
+			if err := tunIoctl(fd, tunSetOwner, uintptr(*config.Owner)); err != nil {
+				return fmt.Errorf("Failed to set owner of tuntap %s due to %s", name, err.Error())
+			}
+		}
+		if config.Group != nil {
+			if err := tunIoctl(fd, tunSetGroup, uintptr(*config.Group)); err != nil {
+				return fmt.Errorf("Failed to set group of tuntap %s due to %s", name, err.Error())
+			}
+		}
+		if config.Persist {
+			if err := tunIoctl(fd, tunSetPersist, 1); err != nil {
+				return fmt.Errorf("Failed to make tuntap %s persistent due to %s", name, err.Error())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		deleteCreated()
+		closeFds()
+		return nil, nil, err
+	}
+	lnk, err := linuxLinkByName(ns, name)
+	if err != nil {
+		deleteCreated()
+		closeFds()
+		return nil, nil, err
+	}
+	// A non blocking descriptor lets the runtime poller drive the queue
+	for _, fd := range fds {
+		if err = syscall.SetNonblock(fd, true); err != nil {
+			deleteCreated()
+			closeFds()
+			return nil, nil, fmt.Errorf("Failed to set tuntap %s non blocking due to %s", name, err.Error())
+		}
+	}
+	files := make([]*os.File, len(fds))
+	for i, fd := range fds {
+		files[i] = os.NewFile(uintptr(fd), tunDevicePath)
+	}
+	return lnk, files, nil
+}
+
+// openTunQueue opens a queue of the named device, creating the device when
+// it does not exist, and returns the queue with the device name
+func openTunQueue(name string, flags uint16) (int, string, error) {
+	fd, err := syscall.Open(tunDevicePath, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, "", fmt.Errorf("Failed to open %s due to %s", tunDevicePath, err.Error())
+	}
+	req := ifreq{flags: flags}
+	copy(req.name[:], name)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNSETIFF,
+		uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		syscall.Close(fd)
+		return -1, "", fmt.Errorf("Failed to attach to tuntap %s due to %s", name, errno.Error())
+	}
+	return fd, string(req.name[:clen(req.name[:])]), nil
+}
+
+func tunIoctl(fd, request, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// clen returns the length of the zero terminated string in b
+func clen(b []byte) int {
+	for i := range b {
+		if b[i] == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
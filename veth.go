@@ -2,14 +2,21 @@ package gonet
 
 import (
 	"fmt"
-	"net"
 
 	"github.com/vishvananda/netlink"
 )
 
 // VethLinkPair is the interface of linux veth link pair
 type VethLinkPair interface {
-	SetPeerIntoNetNS(netnspid int, newName string, ip net.IP, mask net.IPMask) error
+	Host() LinuxLink
+	Peer() LinuxLink
+	Delete() error
+	SetPeerIntoNetNS(netnspid int, newName string, config *IPConfig) error
+	SetPeerIntoDockerNs(containerID, newName string, config *IPConfig) error
+	SetPeerIntoNs(target NetNSRef, newName string, config *IPConfig) error
+	SetPeerIntoContainerNs(runtime, id, newName string, config *IPConfig) error
+	ApplyNetem(profile NetemProfile) error
+	ClearNetem() error
 }
 
 type vethLinkPair struct {
@@ -19,20 +26,41 @@ type vethLinkPair struct {
 
 // NewVethLinkPair ...
 func NewVethLinkPair(ifcName, peerName string) (VethLinkPair, error) {
+	veth, err := newVethLinkPair(nil, ifcName, peerName)
+	if err != nil {
+		return nil, err
+	}
+	return veth, nil
+}
+
+// NewVethLinkPair is used to create a veth link pair inside the namespace
+func (ns *NetNS) NewVethLinkPair(ifcName, peerName string) (VethLinkPair, error) {
+	veth, err := newVethLinkPair(ns, ifcName, peerName)
+	if err != nil {
+		return nil, err
+	}
+	return veth, nil
+}
+
+func newVethLinkPair(ns *NetNS, ifcName, peerName string) (*vethLinkPair, error) {
 	linkAttr := netlink.LinkAttrs{Name: ifcName}
 	vethLink := netlink.Veth{LinkAttrs: linkAttr, PeerName: peerName}
-	err := netlink.LinkAdd(&vethLink)
+	err := ns.run(func() error {
+		return netlink.LinkAdd(&vethLink)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create veth link %s and %s due to %s",
 			ifcName, peerName, err.Error())
 	}
-	ifcLink, err := LinuxLinkByName(ifcName)
+	ifcLink, err := linuxLinkByName(ns, ifcName)
 	if err != nil {
+		deleteLink(ns, ifcName)
 		return nil, fmt.Errorf("Failed to get veth endpoint %s link due to %s",
 			ifcName, err.Error())
 	}
-	peerLink, err := LinuxLinkByName(peerName)
+	peerLink, err := linuxLinkByName(ns, peerName)
 	if err != nil {
+		deleteLink(ns, ifcName)
 		return nil, fmt.Errorf("Failed to get veth endpoint %s link due to %s",
 			peerName, err.Error())
 	}
@@ -40,6 +68,112 @@ func NewVethLinkPair(ifcName, peerName string) (VethLinkPair, error) {
 }
 
 // SetPeerIntoNetNS is used to put the peer into a specific netns
-func (veth *vethLinkPair) SetPeerIntoNetNS(netnspid int, newName string, ip net.IP, mask net.IPMask) error {
-	return veth.PeerLink.SetToNetNs(netnspid, newName, ip, mask)
+func (veth *vethLinkPair) SetPeerIntoNetNS(netnspid int, newName string, config *IPConfig) error {
+	if veth.PeerLink == nil {
+		return fmt.Errorf("The peer of the veth link is not in this namespace")
+	}
+	return veth.PeerLink.SetToNetNs(netnspid, newName, config)
+}
+
+// SetPeerIntoDockerNs is used to put the peer into a docker container's netns
+func (veth *vethLinkPair) SetPeerIntoDockerNs(containerID, newName string, config *IPConfig) error {
+	if veth.PeerLink == nil {
+		return fmt.Errorf("The peer of the veth link is not in this namespace")
+	}
+	return veth.PeerLink.SetToDockerNs(containerID, newName, config)
+}
+
+// SetPeerIntoNs is used to put the peer into the target netns
+func (veth *vethLinkPair) SetPeerIntoNs(target NetNSRef, newName string, config *IPConfig) error {
+	if veth.PeerLink == nil {
+		return fmt.Errorf("The peer of the veth link is not in this namespace")
+	}
+	return veth.PeerLink.SetToNs(target, newName, config)
+}
+
+// SetPeerIntoContainerNs is used to put the peer into the netns of a
+// container of the runtime
+func (veth *vethLinkPair) SetPeerIntoContainerNs(runtime, id, newName string, config *IPConfig) error {
+	if veth.PeerLink == nil {
+		return fmt.Errorf("The peer of the veth link is not in this namespace")
+	}
+	return veth.PeerLink.SetToContainerNs(runtime, id, newName, config)
+}
+
+// Host returns the end the pair was created with
+func (veth *vethLinkPair) Host() LinuxLink {
+	return veth.IfcLink
+}
+
+// Peer returns the other end of the pair. It is nil when the pair was looked
+// up by name and the peer lives in another namespace, a peer moved by the
+// SetPeerInto methods is driven in its new namespace.
+func (veth *vethLinkPair) Peer() LinuxLink {
+	return veth.PeerLink
+}
+
+// Delete is used to delete the pair, removing one end removes both
+func (veth *vethLinkPair) Delete() error {
+	host, err := asLinuxLink(veth.IfcLink)
+	if err != nil {
+		return err
+	}
+	return host.exec(func() error {
+		err := netlink.LinkDel(host.link)
+		if err != nil {
+			return fmt.Errorf("Failed to delete veth link %s due to %s",
+				host.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// VethLinkPairByName is used to get an existing veth pair by either end name
+func VethLinkPairByName(name string) (VethLinkPair, error) {
+	veth, err := vethLinkPairByName(nil, name)
+	if err != nil {
+		return nil, err
+	}
+	return veth, nil
+}
+
+// VethLinkPairByName is used to get an existing veth pair inside the namespace
+func (ns *NetNS) VethLinkPairByName(name string) (VethLinkPair, error) {
+	veth, err := vethLinkPairByName(ns, name)
+	if err != nil {
+		return nil, err
+	}
+	return veth, nil
+}
+
+// vethLinkPairByName resolves the peer through the IFLA_LINK ifindex of the
+// named end. When the peer lives in another namespace the ifindex belongs to
+// that namespace, so the candidate is only accepted if it points back to us.
+func vethLinkPairByName(ns *NetNS, name string) (*vethLinkPair, error) {
+	lnk, err := linuxLinkByName(ns, name)
+	if err != nil {
+		return nil, err
+	}
+	if lnk.link.Type() != "veth" {
+		return nil, fmt.Errorf("The link %s is a %s link instead of veth", name, lnk.link.Type())
+	}
+	veth := &vethLinkPair{IfcLink: lnk}
+	err = ns.run(func() error {
+		peerIndex := lnk.link.Attrs().ParentIndex
+		if peerIndex == 0 {
+			return nil
+		}
+		peer, err := netlink.LinkByIndex(peerIndex)
+		if err != nil {
+			return nil
+		}
+		if peer.Type() == "veth" && peer.Attrs().ParentIndex == lnk.link.Attrs().Index {
+			veth.PeerLink = &linuxLink{link: peer, ns: ns}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return veth, nil
 }
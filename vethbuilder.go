@@ -0,0 +1,338 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// The steps of a veth pair transaction, reported by VethBuildError
+const (
+	VethStepCreate      = "create"
+	VethStepHostAddress = "address-host"
+	VethStepMovePeer    = "move-peer"
+	VethStepRenamePeer  = "rename-peer"
+	VethStepPeerIPv6    = "ipv6-peer"
+	VethStepPeerAddress = "address-peer"
+	VethStepPeerUp      = "up-peer"
+	VethStepPeerRoutes  = "routes-peer"
+	VethStepHostUp      = "up-host"
+)
+
+// VethBuildError is returned by VethPairBuilder.Build when one of the steps
+// failed. All the steps completed before have been undone, RollbackErr holds
+// the first error met while undoing them.
+type VethBuildError struct {
+	Step        string
+	Err         error
+	RollbackErr error
+}
+
+func (e *VethBuildError) Error() string {
+	msg := fmt.Sprintf("Failed to build veth pair at step %s due to %s", e.Step, e.Err.Error())
+	if e.RollbackErr != nil {
+		msg += fmt.Sprintf(", rollback failed due to %s", e.RollbackErr.Error())
+	}
+	return msg
+}
+
+// VethPairBuilder creates a veth pair and configures both of its ends as a
+// single unit. If any step fails, every completed step is undone.
+type VethPairBuilder struct {
+	ns          *NetNS
+	hostName    string
+	peerName    string
+	peerNewName string
+	peerTarget  func() (netns.NsHandle, error)
+	hostAddrs   []*net.IPNet
+	peerConfig  IPConfig
+	up          bool
+}
+
+// NewVethPairBuilder is used to start describing a veth pair
+func NewVethPairBuilder(hostName, peerName string) *VethPairBuilder {
+	return &VethPairBuilder{hostName: hostName, peerName: peerName}
+}
+
+// InNetNS is used to create the pair inside ns instead of the current namespace
+func (b *VethPairBuilder) InNetNS(ns *NetNS) *VethPairBuilder {
+	b.ns = ns
+	return b
+}
+
+// HostIfconfig is used to configure the ips of the host end
+func (b *VethPairBuilder) HostIfconfig(addrs ...*net.IPNet) *VethPairBuilder {
+	b.hostAddrs = append(b.hostAddrs, addrs...)
+	return b
+}
+
+// PeerIfconfig is used to configure the ips of the peer end
+func (b *VethPairBuilder) PeerIfconfig(addrs ...*net.IPNet) *VethPairBuilder {
+	b.peerConfig.Addrs = append(b.peerConfig.Addrs, addrs...)
+	return b
+}
+
+// PeerIPConfig is used to replace the whole ip configuration of the peer end,
+// its routes are only added when the pair is brought up
+func (b *VethPairBuilder) PeerIPConfig(config IPConfig) *VethPairBuilder {
+	b.peerConfig = config
+	return b
+}
+
+// PeerName is used to rename the peer end once it has been moved
+func (b *VethPairBuilder) PeerName(newName string) *VethPairBuilder {
+	b.peerNewName = newName
+	return b
+}
+
+// PeerNetNs is used to move the peer end into the namespace of process nspid
+func (b *VethPairBuilder) PeerNetNs(nspid int) *VethPairBuilder {
+	b.peerTarget = func() (netns.NsHandle, error) {
+		handle, err := netns.GetFromPid(nspid)
+		if err != nil {
+			return handle, fmt.Errorf("Failed to get the net ns for pid %d due to %s",
+				nspid, err.Error())
+		}
+		return handle, nil
+	}
+	return b
+}
+
+// PeerDockerNs is used to move the peer end into a docker container
+func (b *VethPairBuilder) PeerDockerNs(containerID string) *VethPairBuilder {
+	b.peerTarget = func() (netns.NsHandle, error) {
+		if containerID == "" {
+			return netns.None(), fmt.Errorf("The container id cannot be empty")
+		}
+		handle, err := netns.GetFromDocker(containerID)
+		if err != nil {
+			return handle, fmt.Errorf("Failed to get container's network namespace due to %s",
+				err.Error())
+		}
+		return handle, nil
+	}
+	return b
+}
+
+// Up is used to bring both ends up once they are configured
+func (b *VethPairBuilder) Up() *VethPairBuilder {
+	b.up = true
+	return b
+}
+
+// Build is used to run all the steps, the returned error is a *VethBuildError
+// when one of the steps failed
+func (b *VethPairBuilder) Build() (VethLinkPair, error) {
+	if b.hostName == "" || b.peerName == "" {
+		return nil, fmt.Errorf("The names of the veth ends cannot be empty")
+	}
+	tx := &vethTx{}
+	defer tx.release()
+	veth, err := b.build(tx)
+	if err != nil {
+		if buildErr, ok := err.(*VethBuildError); ok {
+			buildErr.RollbackErr = tx.rollback()
+		}
+		return nil, err
+	}
+	return veth, nil
+}
+
+func (b *VethPairBuilder) build(tx *vethTx) (*vethLinkPair, error) {
+	var veth *vethLinkPair
+	err := tx.do(VethStepCreate, func() error {
+		var err error
+		veth, err = newVethLinkPair(b.ns, b.hostName, b.peerName)
+		return err
+	}, func() error {
+		return deleteLink(b.ns, b.hostName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	host := veth.IfcLink.(*linuxLink)
+	peer := veth.PeerLink.(*linuxLink)
+
+	if len(b.hostAddrs) > 0 {
+		err = tx.addAddrs(VethStepHostAddress, host, b.hostAddrs, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.peerTarget != nil {
+		err = tx.movePeer(b.peerTarget, peer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.peerNewName != "" && b.peerNewName != b.peerName {
+		oldName := b.peerName
+		err = tx.do(VethStepRenamePeer, func() error {
+			return peer.SetName(b.peerNewName)
+		}, func() error {
+			return peer.SetName(oldName)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The sysctls live and die with the link, there is nothing to undo
+	err = tx.do(VethStepPeerIPv6, func() error {
+		return peer.exec(func() error {
+			return applyIPv6Sysctls(peer.link.Attrs().Name, &b.peerConfig)
+		})
+	}, func() error { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b.peerConfig.Addrs) > 0 {
+		err = tx.addAddrs(VethStepPeerAddress, peer, b.peerConfig.Addrs, b.peerConfig.DisableDAD)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.up {
+		err = tx.do(VethStepPeerUp, peer.Up, peer.Down)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range b.peerConfig.Routes {
+			if route == nil {
+				continue
+			}
+			route := route
+			err = tx.do(VethStepPeerRoutes, func() error {
+				return peer.AddRoute(route)
+			}, func() error {
+				return peer.DelRoute(route)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = tx.do(VethStepHostUp, host.Up, host.Down)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return veth, nil
+}
+
+// vethTx records the undo action of every completed step
+type vethTx struct {
+	undo     []func() error
+	releases []func()
+}
+
+// do runs the step and registers its undo action once it succeeded
+func (tx *vethTx) do(step string, fn func() error, undo func() error) error {
+	if err := fn(); err != nil {
+		return &VethBuildError{Step: step, Err: err}
+	}
+	tx.undo = append(tx.undo, undo)
+	return nil
+}
+
+// rollback undoes the completed steps in reverse order
+func (tx *vethTx) rollback() error {
+	var firstErr error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	tx.undo = nil
+	return firstErr
+}
+
+// release frees the resources held by the transaction once it is over
+func (tx *vethTx) release() {
+	for i := len(tx.releases) - 1; i >= 0; i-- {
+		tx.releases[i]()
+	}
+	tx.releases = nil
+}
+
+// addAddrs adds the addresses one by one so that each of them can be undone
+func (tx *vethTx) addAddrs(step string, lnk *linuxLink, addrs []*net.IPNet, noDad bool) error {
+	for _, ipNet := range addrs {
+		addr, err := normalizeAddr(ipNet)
+		if err != nil {
+			return &VethBuildError{Step: step, Err: err}
+		}
+		err = tx.do(step, func() error {
+			return lnk.exec(func() error {
+				return addAddrs(lnk.link, []*net.IPNet{addr.IPNet}, noDad)
+			})
+		}, func() error {
+			return lnk.exec(func() error {
+				return netlink.AddrDel(lnk.link, addr)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// movePeer moves the peer end into the target namespace, the peer is then
+// driven through a NetNS of the target which lives as long as the peer.
+// Undoing it moves the peer back where it came from.
+func (tx *vethTx) movePeer(openTarget func() (netns.NsHandle, error), peer *linuxLink) error {
+	handle, err := openTarget()
+	if err != nil {
+		return &VethBuildError{Step: VethStepMovePeer, Err: err}
+	}
+	target := lightNetNS(handle)
+	var origin netns.NsHandle
+	err = peer.exec(func() error {
+		var err error
+		origin, err = netns.Get()
+		return err
+	})
+	if err != nil {
+		target.Close()
+		return &VethBuildError{Step: VethStepMovePeer,
+			Err: fmt.Errorf("Failed to get current net ns due to %s", err.Error())}
+	}
+	originNs := peer.ns
+	tx.releases = append(tx.releases, func() {
+		origin.Close()
+	})
+	return tx.do(VethStepMovePeer, func() error {
+		err := peer.Down()
+		if err != nil {
+			return err
+		}
+		err = peer.exec(func() error {
+			return netlink.LinkSetNsFd(peer.link, int(target.Handle()))
+		})
+		if err != nil {
+			return err
+		}
+		peer.ns = target
+		// The ifindex may change when the link enters the new namespace
+		return peer.refresh()
+	}, func() error {
+		err := peer.exec(func() error {
+			link, err := netlink.LinkByName(peer.link.Attrs().Name)
+			if err != nil {
+				return err
+			}
+			return netlink.LinkSetNsFd(link, int(origin))
+		})
+		if err != nil {
+			return err
+		}
+		peer.ns = originNs
+		return peer.refresh()
+	})
+}
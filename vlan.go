@@ -0,0 +1,99 @@
+package gonet
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// iflaVlanQosMapping is IFLA_VLAN_QOS_MAPPING of the vlan qos maps
+const iflaVlanQosMapping = 1
+
+// VlanProtocol is the ethertype of the vlan tag
+type VlanProtocol uint16
+
+// The supported vlan protocols
+const (
+	VlanProtocol8021Q  VlanProtocol = 0x8100
+	VlanProtocol8021AD VlanProtocol = 0x88a8
+)
+
+// VlanConfig describes a vlan sub-interface
+type VlanConfig struct {
+	Name string
+	// ID is the vlan id, from 1 to 4094
+	ID int
+	// Protocol defaults to 802.1Q, 802.1ad is used for QinQ outer tags
+	Protocol VlanProtocol
+	// IngressQoS maps the 802.1p priority of received frames to the skb
+	// priority
+	IngressQoS map[uint32]uint32
+	// EgressQoS maps the skb priority of sent frames to the 802.1p priority
+	EgressQoS map[uint32]uint32
+}
+
+// NewVlanLink is used to create an 802.1Q vlan sub-interface of parent
+func NewVlanLink(parent LinuxLink, name string, vid int) (LinuxLink, error) {
+	return NewVlanLinkWithConfig(parent, VlanConfig{Name: name, ID: vid})
+}
+
+// NewVlanLinkWithConfig is used to create a vlan sub-interface of parent, the
+// link is created in the namespace of parent
+func NewVlanLinkWithConfig(parent LinuxLink, config VlanConfig) (LinuxLink, error) {
+	parentLink, err := asLinuxLink(parent)
+	if err != nil {
+		return nil, err
+	}
+	if config.ID < 1 || config.ID > 4094 {
+		return nil, fmt.Errorf("The vlan id %d is not valid", config.ID)
+	}
+	protocol := config.Protocol
+	if protocol == 0 {
+		protocol = VlanProtocol8021Q
+	}
+	if protocol != VlanProtocol8021Q && protocol != VlanProtocol8021AD {
+		return nil, fmt.Errorf("The vlan protocol %#x is not valid", uint16(protocol))
+	}
+	for prio := range config.IngressQoS {
+		if prio > 7 {
+			return nil, fmt.Errorf("The ingress 802.1p priority %d is not valid", prio)
+		}
+	}
+	for _, prio := range config.EgressQoS {
+		if prio > 7 {
+			return nil, fmt.Errorf("The egress 802.1p priority %d is not valid", prio)
+		}
+	}
+
+	lnk, err := addLinuxLinkInfo(parentLink.ns, config.Name, "vlan", parentLink.link.Attrs().Index,
+		func(data *nl.RtAttr) {
+			nl.NewRtAttrChild(data, nl.IFLA_VLAN_ID, nl.Uint16Attr(uint16(config.ID)))
+			nl.NewRtAttrChild(data, nl.IFLA_VLAN_PROTOCOL, htons(uint16(protocol)))
+			addVlanQosMap(data, nl.IFLA_VLAN_INGRESS_QOS, config.IngressQoS)
+			addVlanQosMap(data, nl.IFLA_VLAN_EGRESS_QOS, config.EgressQoS)
+		})
+	if err != nil {
+		return nil, err
+	}
+	return lnk, nil
+}
+
+// addVlanQosMap encodes the map as a list of struct ifla_vlan_qos_mapping
+func addVlanQosMap(data *nl.RtAttr, attrType int, qos map[uint32]uint32) {
+	if len(qos) == 0 {
+		return
+	}
+	native := nl.NativeEndian()
+	qosMap := nl.NewRtAttrChild(data, attrType, nil)
+	for from, to := range qos {
+		mapping := make([]byte, 8)
+		native.PutUint32(mapping[0:4], from)
+		native.PutUint32(mapping[4:8], to)
+		nl.NewRtAttrChild(qosMap, iflaVlanQosMapping, mapping)
+	}
+}
+
+// htons encodes the value in network byte order
+func htons(value uint16) []byte {
+	return []byte{byte(value >> 8), byte(value)}
+}
@@ -0,0 +1,272 @@
+package gonet
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// VxlanPortIANA is the vxlan port assigned by IANA, the kernel defaults to
+// the legacy 8472 when no port is given
+const VxlanPortIANA = 4789
+
+// VxlanConfig describes a vxlan device
+type VxlanConfig struct {
+	Name string
+	// VNI is the vxlan network identifier, from 0 to 16777215
+	VNI int
+	// Local is the source address of the encapsulated packets
+	Local net.IP
+	// Remote is the unicast VTEP the unknown destinations are sent to,
+	// it cannot be combined with Group
+	Remote net.IP
+	// Group is the multicast group the unknown destinations are sent to
+	Group net.IP
+	// Dev is the underlay link, it is needed to join Group
+	Dev LinuxLink
+	// Port is the udp destination port, 0 keeps the kernel default
+	Port int
+	// TTL of the outer packets, 0 uses the default ttl of the route
+	TTL int
+	// Learning turns on the learning of remote macs from received packets
+	Learning bool
+	// L2Miss and L3Miss notify user space of missing fdb and neighbour
+	// entries
+	L2Miss bool
+	L3Miss bool
+	// UDPCSum computes the udp checksum of IPv4 encapsulated packets
+	UDPCSum bool
+	// UDP6ZeroCSumTx and UDP6ZeroCSumRx allow a zero udp checksum on
+	// IPv6 encapsulated packets
+	UDP6ZeroCSumTx bool
+	UDP6ZeroCSumRx bool
+}
+
+// VxlanFDBEntry is a static forwarding entry of a vxlan device, a zero MAC
+// is the default entry used for the broadcast and unknown destinations
+type VxlanFDBEntry struct {
+	MAC  net.HardwareAddr
+	VTEP net.IP
+}
+
+// Vxlan is the interface of a vxlan device, it is a LinuxLink itself and
+// can be added to a Bridge of the same namespace as a port
+type Vxlan interface {
+	LinuxLink
+	Delete() error
+	VNI() int
+	AddFDB(entry VxlanFDBEntry) error
+	DelFDB(entry VxlanFDBEntry) error
+	FDB() ([]VxlanFDBEntry, error)
+}
+
+type vxlan struct {
+	*linuxLink
+}
+
+// NewVxlan is used to create a vxlan device
+func NewVxlan(config VxlanConfig) (Vxlan, error) {
+	return newVxlan(nil, config)
+}
+
+// NewVxlan is used to create a vxlan device inside the namespace
+func (ns *NetNS) NewVxlan(config VxlanConfig) (Vxlan, error) {
+	return newVxlan(ns, config)
+}
+
+// VxlanByName is used to get an existing vxlan device
+func VxlanByName(name string) (Vxlan, error) {
+	return vxlanByName(nil, name)
+}
+
+// VxlanByName is used to get an existing vxlan device inside the namespace
+func (ns *NetNS) VxlanByName(name string) (Vxlan, error) {
+	return vxlanByName(ns, name)
+}
+
+func newVxlan(ns *NetNS, config VxlanConfig) (Vxlan, error) {
+	if config.VNI < 0 || config.VNI > 0xffffff {
+		return nil, fmt.Errorf("The vni %d is not valid", config.VNI)
+	}
+	if config.Remote != nil && config.Group != nil {
+		return nil, fmt.Errorf("The remote and the group of the vxlan cannot be both given")
+	}
+	if config.Remote != nil && config.Remote.IsMulticast() {
+		return nil, fmt.Errorf("The remote %s of the vxlan is a multicast address", config.Remote)
+	}
+	if config.Group != nil && !config.Group.IsMulticast() {
+		return nil, fmt.Errorf("The group %s of the vxlan is not a multicast address", config.Group)
+	}
+	if config.Port < 0 || config.Port > 0xffff {
+		return nil, fmt.Errorf("The port %d of the vxlan is not valid", config.Port)
+	}
+	if config.TTL < 0 || config.TTL > 0xff {
+		return nil, fmt.Errorf("The ttl %d of the vxlan is not valid", config.TTL)
+	}
+	devIndex := 0
+	if config.Dev != nil {
+		dev, err := asLinuxLink(config.Dev)
+		if err != nil {
+			return nil, err
+		}
+		same, err := sameNetNS(dev.ns, ns)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			return nil, fmt.Errorf("The link %s is not in the net ns of the vxlan", dev.link.Attrs().Name)
+		}
+		devIndex = dev.link.Attrs().Index
+	}
+
+	lnk, err := addLinuxLinkInfo(ns, config.Name, "vxlan", 0, func(data *nl.RtAttr) {
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_ID, nl.Uint32Attr(uint32(config.VNI)))
+		if devIndex != 0 {
+			nl.NewRtAttrChild(data, nl.IFLA_VXLAN_LINK, nl.Uint32Attr(uint32(devIndex)))
+		}
+		if config.Local != nil {
+			if ip4 := config.Local.To4(); ip4 != nil {
+				nl.NewRtAttrChild(data, nl.IFLA_VXLAN_LOCAL, []byte(ip4))
+			} else {
+				nl.NewRtAttrChild(data, nl.IFLA_VXLAN_LOCAL6, []byte(config.Local.To16()))
+			}
+		}
+		// The kernel keeps both the remote and the group in the group
+		// attribute and tells them apart by the address
+		group := config.Group
+		if group == nil {
+			group = config.Remote
+		}
+		if group != nil {
+			if ip4 := group.To4(); ip4 != nil {
+				nl.NewRtAttrChild(data, nl.IFLA_VXLAN_GROUP, []byte(ip4))
+			} else {
+				nl.NewRtAttrChild(data, nl.IFLA_VXLAN_GROUP6, []byte(group.To16()))
+			}
+		}
+		if config.Port != 0 {
+			nl.NewRtAttrChild(data, nl.IFLA_VXLAN_PORT, htons(uint16(config.Port)))
+		}
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_TTL, nl.Uint8Attr(uint8(config.TTL)))
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_LEARNING, boolAttr(config.Learning))
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_L2MISS, boolAttr(config.L2Miss))
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_L3MISS, boolAttr(config.L3Miss))
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_UDP_CSUM, boolAttr(config.UDPCSum))
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_UDP_ZERO_CSUM6_TX, boolAttr(config.UDP6ZeroCSumTx))
+		nl.NewRtAttrChild(data, nl.IFLA_VXLAN_UDP_ZERO_CSUM6_RX, boolAttr(config.UDP6ZeroCSumRx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &vxlan{linuxLink: lnk}, nil
+}
+
+func vxlanByName(ns *NetNS, name string) (Vxlan, error) {
+	lnk, err := linuxLinkByName(ns, name)
+	if err != nil {
+		return nil, err
+	}
+	if lnk.link.Type() != "vxlan" {
+		return nil, fmt.Errorf("The link %s is a %s link instead of vxlan", name, lnk.link.Type())
+	}
+	return &vxlan{linuxLink: lnk}, nil
+}
+
+// Delete is used to delete the vxlan device
+func (vx *vxlan) Delete() error {
+	return vx.exec(func() error {
+		err := netlink.LinkDel(vx.link)
+		if err != nil {
+			return fmt.Errorf("Failed to delete vxlan %s due to %s",
+				vx.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// VNI is used to get the vxlan network identifier
+func (vx *vxlan) VNI() int {
+	if link, ok := vx.link.(*netlink.Vxlan); ok {
+		return link.VxlanId
+	}
+	return 0
+}
+
+// AddFDB is used to point the mac at the VTEP. The default entry is
+// appended so that the unknown destinations are flooded to every VTEP,
+// any other mac is moved to the new VTEP.
+func (vx *vxlan) AddFDB(entry VxlanFDBEntry) error {
+	flags := syscall.NLM_F_CREATE | syscall.NLM_F_REPLACE
+	if isZeroMAC(entry.MAC) {
+		flags = syscall.NLM_F_CREATE | syscall.NLM_F_APPEND
+	}
+	return vx.modifyFDB(entry, syscall.RTM_NEWNEIGH, flags)
+}
+
+// DelFDB is used to delete the fdb entry
+func (vx *vxlan) DelFDB(entry VxlanFDBEntry) error {
+	return vx.modifyFDB(entry, syscall.RTM_DELNEIGH, 0)
+}
+
+func (vx *vxlan) modifyFDB(entry VxlanFDBEntry, cmd, flags int) error {
+	if len(entry.MAC) != 6 {
+		return fmt.Errorf("The mac %s of the fdb entry is not valid", entry.MAC)
+	}
+	if entry.VTEP == nil {
+		return fmt.Errorf("The vtep of the fdb entry cannot be empty")
+	}
+	return vx.exec(func() error {
+		msg := &netlink.Ndmsg{
+			Family: syscall.AF_BRIDGE,
+			Index:  uint32(vx.link.Attrs().Index),
+			State:  netlink.NUD_PERMANENT | netlink.NUD_NOARP,
+			Flags:  netlink.NTF_SELF,
+		}
+		err := neighRequest(cmd, flags, msg, entry.VTEP, entry.MAC, 0)
+		if err != nil {
+			return fmt.Errorf("Failed to modify fdb entry %s of vxlan %s due to %s",
+				entry.MAC, vx.link.Attrs().Name, err.Error())
+		}
+		return nil
+	})
+}
+
+// FDB is used to list the forwarding entries of the vxlan device, both the
+// static and the learnt ones
+func (vx *vxlan) FDB() ([]VxlanFDBEntry, error) {
+	var entries []VxlanFDBEntry
+	err := vx.exec(func() error {
+		neighs, err := netlink.NeighList(vx.link.Attrs().Index, syscall.AF_BRIDGE)
+		if err != nil {
+			return fmt.Errorf("Failed to list fdb of vxlan %s due to %s",
+				vx.link.Attrs().Name, err.Error())
+		}
+		for _, neigh := range neighs {
+			if neigh.Flags&netlink.NTF_SELF == 0 || neigh.IP == nil {
+				continue
+			}
+			entries = append(entries, VxlanFDBEntry{MAC: neigh.HardwareAddr, VTEP: neigh.IP})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func isZeroMAC(mac net.HardwareAddr) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func boolAttr(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,518 @@
+package gonet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The IFLA attribute telling the namespace a deleted link moved to, the
+// vendored nl package does not define it
+const iflaNewNetnsID = 45
+
+const (
+	// watchBufferSize is large enough for the biggest rtnetlink messages,
+	// the vendored subscriptions read a page only and drop the rest
+	watchBufferSize = 64 << 10
+	// watchPollInterval bounds the time a cancelled watcher keeps its socket
+	watchPollInterval = 500 * time.Millisecond
+	// watchRetryInterval paces the subscription attempts after an error
+	watchRetryInterval = time.Second
+	watchEventQueue    = 64
+)
+
+// EventType is the type of the events delivered by a Watcher
+type EventType int
+
+// The event types
+const (
+	EventLinkAdded EventType = iota + 1
+	EventLinkRemoved
+	EventLinkRenamed
+	// EventLinkUp is a link which is administratively up and has a carrier,
+	// EventLinkDown one which lost either. An admin-up link without carrier
+	// yet, like a veth whose peer is down, stays down.
+	EventLinkUp
+	EventLinkDown
+	// EventLinkMoved is a link which left the namespace for another one
+	EventLinkMoved
+	EventAddrAdded
+	EventAddrRemoved
+	EventRouteAdded
+	EventRouteRemoved
+	// EventResync follows a resubscription, the link events missed in
+	// between have been replayed but the address and route ones are lost
+	EventResync
+)
+
+var eventTypeNames = map[EventType]string{
+	EventLinkAdded:    "link-added",
+	EventLinkRemoved:  "link-removed",
+	EventLinkRenamed:  "link-renamed",
+	EventLinkUp:       "link-up",
+	EventLinkDown:     "link-down",
+	EventLinkMoved:    "link-moved",
+	EventAddrAdded:    "addr-added",
+	EventAddrRemoved:  "addr-removed",
+	EventRouteAdded:   "route-added",
+	EventRouteRemoved: "route-removed",
+	EventResync:       "resync",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("event-%d", int(t))
+}
+
+// Event is a change of the links, addresses or routes of a namespace
+type Event struct {
+	Type EventType
+	// Index and Name are the ifindex and name of the link the event is
+	// about, the outgoing link for the routes
+	Index int
+	Name  string
+	// OldName is the name a renamed link had before
+	OldName string
+	// NetNSID is the id the watched namespace gives to the namespace a
+	// moved link went to
+	NetNSID int
+	// Addr is the address of the address events
+	Addr *net.IPNet
+	// Route is the route of the route events
+	Route *Route
+}
+
+func (e Event) String() string {
+	switch {
+	case e.Addr != nil:
+		return fmt.Sprintf("%s %s dev %s", e.Type, e.Addr, e.Name)
+	case e.Route != nil:
+		return fmt.Sprintf("%s %s", e.Type, e.Route)
+	case e.OldName != "":
+		return fmt.Sprintf("%s %s to %s", e.Type, e.OldName, e.Name)
+	}
+	return fmt.Sprintf("%s %s", e.Type, e.Name)
+}
+
+// WatchFilter selects the events a Watcher delivers, EventResync is always
+// delivered
+type WatchFilter struct {
+	// Types are the event types wanted, empty for all of them
+	Types []EventType
+	// Names are shell patterns the link names are matched against, such as
+	// veth*, empty for all the links
+	Names []string
+}
+
+// Watcher delivers the events of a namespace until its context is done. It
+// subscribes again by itself when the netlink socket fails, for instance
+// when the kernel drops events because they were not read fast enough.
+type Watcher struct {
+	ns *NetNS
+	// ownsNS is set when ns was opened by the watcher and is closed with it
+	ownsNS bool
+	filter WatchFilter
+	events chan Event
+	err    error
+	// links is the state the link events are derived from
+	links map[int]watchedLink
+	// names caches the link names for the route parsing
+	names map[int]string
+}
+
+type watchedLink struct {
+	name string
+	up   bool
+}
+
+// NewWatcher is used to watch the namespace of the calling thread, such as
+// the one WithNetNS switched to. The watcher keeps that namespace when it
+// subscribes again from another thread.
+func NewWatcher(ctx context.Context, filter *WatchFilter) (*Watcher, error) {
+	ns, err := threadNetNS()
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWatcher(ctx, ns, ns != nil, filter)
+	if err != nil {
+		if ns != nil {
+			ns.Close()
+		}
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWatcher is used to watch the namespace, it must stay open while watched
+func (ns *NetNS) NewWatcher(ctx context.Context, filter *WatchFilter) (*Watcher, error) {
+	return newWatcher(ctx, ns, false, filter)
+}
+
+// newWatcher starts watching ns, which is closed once the watcher stops when
+// owned is set
+func newWatcher(ctx context.Context, ns *NetNS, owned bool, filter *WatchFilter) (*Watcher, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("The context cannot be nil")
+	}
+	w := &Watcher{ns: ns, ownsNS: owned, events: make(chan Event, watchEventQueue)}
+	if filter != nil {
+		w.filter = *filter
+	}
+	for _, pattern := range w.filter.Names {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("The link name pattern %s is not valid", pattern)
+		}
+	}
+	fd, err := w.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	links, err := w.listLinks()
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	w.setLinks(links)
+	go w.run(ctx, fd)
+	return w, nil
+}
+
+// Events returns the channel the events are delivered on, it is closed once
+// the watcher stops
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns why the watcher stopped, it is only valid once the events
+// channel is closed
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// run reads the socket and subscribes again whenever it fails
+func (w *Watcher) run(ctx context.Context, fd int) {
+	defer close(w.events)
+	if w.ownsNS {
+		defer w.ns.Close()
+	}
+	for {
+		err := w.receive(ctx, fd)
+		syscall.Close(fd)
+		if ctx.Err() != nil {
+			w.err = ctx.Err()
+			return
+		}
+		for {
+			if w.ns.isClosed() {
+				w.err = fmt.Errorf("The watched net ns has been closed after %s", err.Error())
+				return
+			}
+			select {
+			case <-ctx.Done():
+				w.err = ctx.Err()
+				return
+			case <-time.After(watchRetryInterval):
+			}
+			if fd, err = w.subscribe(); err == nil {
+				if err = w.resync(ctx); err == nil {
+					break
+				}
+				syscall.Close(fd)
+			}
+		}
+	}
+}
+
+// subscribe opens a socket of the namespace listening to the groups of the
+// wanted events
+func (w *Watcher) subscribe() (int, error) {
+	var groups []uint
+	if w.wants(EventLinkAdded, EventLinkRemoved, EventLinkRenamed, EventLinkUp,
+		EventLinkDown, EventLinkMoved, EventAddrAdded, EventAddrRemoved,
+		EventRouteAdded, EventRouteRemoved) {
+		// The link events also keep the names of the other events current
+		groups = append(groups, syscall.RTNLGRP_LINK)
+	}
+	if w.wants(EventAddrAdded, EventAddrRemoved) {
+		groups = append(groups, syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR)
+	}
+	if w.wants(EventRouteAdded, EventRouteRemoved) {
+		groups = append(groups, syscall.RTNLGRP_IPV4_ROUTE, syscall.RTNLGRP_IPV6_ROUTE)
+	}
+	fd := -1
+	err := w.ns.run(func() error {
+		s, err := nl.Subscribe(syscall.NETLINK_ROUTE, groups...)
+		if err != nil {
+			return err
+		}
+		fd = s.GetFd()
+		return nil
+	})
+	if err != nil {
+		return -1, fmt.Errorf("Failed to subscribe to the net ns events due to %s", err.Error())
+	}
+	timeout := syscall.NsecToTimeval(watchPollInterval.Nanoseconds())
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+	if err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("Failed to set the timeout of the event socket due to %s", err.Error())
+	}
+	return fd, nil
+}
+
+// receive delivers the events read from fd until the context is done or the
+// socket fails
+func (w *Watcher) receive(ctx context.Context, fd int) error {
+	buf := make([]byte, watchBufferSize)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// The events keep slices of the messages, buf is reused
+		msgs, err := syscall.ParseNetlinkMessage(append([]byte(nil), buf[:n]...))
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			for _, event := range w.parse(m) {
+				if !w.emit(ctx, event) {
+					return ctx.Err()
+				}
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// parse turns a message into events, updating the link state
+func (w *Watcher) parse(m syscall.NetlinkMessage) []Event {
+	switch m.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		// The bridge reports its port changes with link messages too
+		if len(m.Data) > 0 && m.Data[0] == syscall.AF_BRIDGE {
+			return nil
+		}
+		lm, err := parseLinkMsg(m.Data)
+		if err != nil {
+			return nil
+		}
+		link := watchedLink{name: lm.name(), up: linkUp(lm.flags)}
+		index := lm.index
+		if m.Header.Type == syscall.RTM_NEWLINK {
+			return w.updateLink(index, link)
+		}
+		delete(w.links, index)
+		delete(w.names, index)
+		if value := lm.attr(iflaNewNetnsID); len(value) >= 4 {
+			netnsID := int(int32(nl.NativeEndian().Uint32(value[0:4])))
+			return []Event{{Type: EventLinkMoved, Index: index, Name: link.name, NetNSID: netnsID}}
+		}
+		return []Event{{Type: EventLinkRemoved, Index: index, Name: link.name}}
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			return nil
+		}
+		msg := nl.DeserializeIfAddrmsg(m.Data)
+		attrs, err := nl.ParseRouteAttr(m.Data[syscall.SizeofIfAddrmsg:])
+		if err != nil {
+			return nil
+		}
+		var ip net.IP
+		for _, attr := range attrs {
+			// The local address differs from the peer one of point to point links
+			if attr.Attr.Type == syscall.IFA_LOCAL || (attr.Attr.Type == syscall.IFA_ADDRESS && ip == nil) {
+				ip = net.IP(attr.Value)
+			}
+		}
+		if ip == nil {
+			return nil
+		}
+		event := Event{
+			Type:  EventAddrAdded,
+			Index: int(msg.Index),
+			Name:  w.links[int(msg.Index)].name,
+			Addr:  &net.IPNet{IP: ip, Mask: net.CIDRMask(int(msg.Prefixlen), 8*len(ip))},
+		}
+		if m.Header.Type == syscall.RTM_DELADDR {
+			event.Type = EventAddrRemoved
+		}
+		return []Event{event}
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		if len(m.Data) < syscall.SizeofRtMsg {
+			return nil
+		}
+		msg := nl.DeserializeRtMsg(m.Data)
+		if msg.Flags&syscall.RTM_F_CLONED != 0 || msg.Table == syscall.RT_TABLE_LOCAL {
+			return nil
+		}
+		var route Route
+		var indexes []int
+		// The names missing from the cache are looked up in the namespace
+		err := w.ns.run(func() error {
+			var err error
+			route, indexes, err = parseRoute(m.Data, w.names)
+			return err
+		})
+		if err != nil {
+			return nil
+		}
+		event := Event{Type: EventRouteAdded, Name: route.LinkName, Route: &route}
+		if len(indexes) > 0 {
+			event.Index = indexes[0]
+			event.Name = w.names[indexes[0]]
+		}
+		if m.Header.Type == syscall.RTM_DELROUTE {
+			event.Type = EventRouteRemoved
+		}
+		return []Event{event}
+	}
+	return nil
+}
+
+// updateLink records the new state of the link and returns the transitions
+func (w *Watcher) updateLink(index int, link watchedLink) []Event {
+	old, known := w.links[index]
+	w.links[index] = link
+	w.names[index] = link.name
+	if !known {
+		return []Event{{Type: EventLinkAdded, Index: index, Name: link.name}}
+	}
+	var events []Event
+	if old.name != link.name {
+		events = append(events, Event{Type: EventLinkRenamed, Index: index, Name: link.name,
+			OldName: old.name})
+	}
+	if old.up != link.up {
+		event := Event{Type: EventLinkDown, Index: index, Name: link.name}
+		if link.up {
+			event.Type = EventLinkUp
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// resync replays the link changes missed while the socket was down
+func (w *Watcher) resync(ctx context.Context) error {
+	links, err := w.listLinks()
+	if err != nil {
+		return err
+	}
+	for _, event := range w.diffLinks(links) {
+		if !w.emit(ctx, event) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// diffLinks records the listed links and returns the events leading to them
+// from the recorded state, followed by EventResync
+func (w *Watcher) diffLinks(links map[int]watchedLink) []Event {
+	var events []Event
+	for index, old := range w.links {
+		if _, ok := links[index]; !ok {
+			events = append(events, Event{Type: EventLinkRemoved, Index: index, Name: old.name})
+			delete(w.links, index)
+			delete(w.names, index)
+		}
+	}
+	for index, link := range links {
+		events = append(events, w.updateLink(index, link)...)
+	}
+	return append(events, Event{Type: EventResync})
+}
+
+// listLinks returns the state of the links of the namespace
+func (w *Watcher) listLinks() (map[int]watchedLink, error) {
+	links := make(map[int]watchedLink)
+	err := w.ns.run(func() error {
+		msgs, err := getLinkMsgs(0)
+		if err != nil {
+			return err
+		}
+		for _, lm := range msgs {
+			links[lm.index] = watchedLink{name: lm.name(), up: linkUp(lm.flags)}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the links of the watched net ns due to %s", err.Error())
+	}
+	return links, nil
+}
+
+func (w *Watcher) setLinks(links map[int]watchedLink) {
+	w.links = links
+	w.names = make(map[int]string)
+	for index, link := range links {
+		w.names[index] = link.name
+	}
+}
+
+// emit delivers the event when the filter wants it, it returns false once
+// the context is done
+func (w *Watcher) emit(ctx context.Context, event Event) bool {
+	if !w.match(event) {
+		return true
+	}
+	select {
+	case w.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *Watcher) match(event Event) bool {
+	if event.Type == EventResync {
+		return true
+	}
+	if !w.wants(event.Type) {
+		return false
+	}
+	if len(w.filter.Names) == 0 {
+		return true
+	}
+	for _, pattern := range w.filter.Names {
+		if ok, _ := filepath.Match(pattern, event.Name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, event.OldName); ok && event.OldName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// wants tells whether the filter wants any of the types
+func (w *Watcher) wants(types ...EventType) bool {
+	if len(w.filter.Types) == 0 {
+		return true
+	}
+	for _, wanted := range w.filter.Types {
+		for _, t := range types {
+			if wanted == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// linkUp tells whether the link is administratively up with a carrier, the
+// state EventLinkUp and EventLinkDown report
+func linkUp(flags uint32) bool {
+	return flags&syscall.IFF_UP != 0 && flags&syscall.IFF_RUNNING != 0
+}
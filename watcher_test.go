@@ -0,0 +1,246 @@
+package gonet
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// linkMessage crafts a link message like the kernel sends them
+func linkMessage(kind uint16, family uint8, index int32, flags uint32, attrs ...*nl.RtAttr) syscall.NetlinkMessage {
+	msg := nl.NewIfInfomsg(int(family))
+	msg.Index = index
+	msg.Flags = flags
+	data := msg.Serialize()
+	for _, attr := range attrs {
+		data = append(data, attr.Serialize()...)
+	}
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: kind}, Data: data}
+}
+
+func ifname(name string) *nl.RtAttr {
+	return nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(name))
+}
+
+func newTestWatcher(filter WatchFilter, links map[int]watchedLink) *Watcher {
+	w := &Watcher{filter: filter}
+	w.setLinks(links)
+	return w
+}
+
+const upRunning = syscall.IFF_UP | syscall.IFF_RUNNING
+
+func TestWatcherParseLink(t *testing.T) {
+	netnsID := nl.NewRtAttr(iflaNewNetnsID, nl.Uint32Attr(3))
+	tests := []struct {
+		name   string
+		msg    syscall.NetlinkMessage
+		events []Event
+		links  map[int]watchedLink
+	}{
+		{
+			name:   "new link",
+			msg:    linkMessage(syscall.RTM_NEWLINK, syscall.AF_UNSPEC, 2, 0, ifname("veth0")),
+			events: []Event{{Type: EventLinkAdded, Index: 2, Name: "veth0"}},
+			links:  map[int]watchedLink{1: {"eth0", true}, 2: {"veth0", false}},
+		},
+		{
+			name:   "renamed",
+			msg:    linkMessage(syscall.RTM_NEWLINK, syscall.AF_UNSPEC, 1, upRunning, ifname("wan0")),
+			events: []Event{{Type: EventLinkRenamed, Index: 1, Name: "wan0", OldName: "eth0"}},
+			links:  map[int]watchedLink{1: {"wan0", true}},
+		},
+		{
+			name:   "admin up without carrier",
+			msg:    linkMessage(syscall.RTM_NEWLINK, syscall.AF_UNSPEC, 1, syscall.IFF_UP, ifname("eth0")),
+			events: []Event{{Type: EventLinkDown, Index: 1, Name: "eth0"}},
+			links:  map[int]watchedLink{1: {"eth0", false}},
+		},
+		{
+			name:  "unchanged",
+			msg:   linkMessage(syscall.RTM_NEWLINK, syscall.AF_UNSPEC, 1, upRunning, ifname("eth0")),
+			links: map[int]watchedLink{1: {"eth0", true}},
+		},
+		{
+			name:   "removed",
+			msg:    linkMessage(syscall.RTM_DELLINK, syscall.AF_UNSPEC, 1, upRunning, ifname("eth0")),
+			events: []Event{{Type: EventLinkRemoved, Index: 1, Name: "eth0"}},
+			links:  map[int]watchedLink{},
+		},
+		{
+			name:   "moved",
+			msg:    linkMessage(syscall.RTM_DELLINK, syscall.AF_UNSPEC, 1, 0, ifname("eth0"), netnsID),
+			events: []Event{{Type: EventLinkMoved, Index: 1, Name: "eth0", NetNSID: 3}},
+			links:  map[int]watchedLink{},
+		},
+		{
+			name:  "bridge port",
+			msg:   linkMessage(syscall.RTM_NEWLINK, syscall.AF_BRIDGE, 1, 0, ifname("eth0")),
+			links: map[int]watchedLink{1: {"eth0", true}},
+		},
+		{
+			name: "truncated",
+			msg: syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK},
+				Data: []byte{syscall.AF_UNSPEC, 0, 0}},
+			links: map[int]watchedLink{1: {"eth0", true}},
+		},
+	}
+	for _, test := range tests {
+		w := newTestWatcher(WatchFilter{}, map[int]watchedLink{1: {"eth0", true}})
+		events := w.parse(test.msg)
+		if !reflect.DeepEqual(events, test.events) {
+			t.Errorf("%s: got events %v, want %v", test.name, events, test.events)
+		}
+		if !reflect.DeepEqual(w.links, test.links) {
+			t.Errorf("%s: got links %v, want %v", test.name, w.links, test.links)
+		}
+		for index, link := range w.links {
+			if w.names[index] != link.name {
+				t.Errorf("%s: the name of link %d is %q instead of %q", test.name, index,
+					w.names[index], link.name)
+			}
+		}
+	}
+}
+
+func TestWatcherParseAddr(t *testing.T) {
+	addrMessage := func(kind uint16, prefixlen uint8, attrs ...*nl.RtAttr) syscall.NetlinkMessage {
+		msg := nl.NewIfAddrmsg(syscall.AF_INET)
+		msg.Index = 1
+		msg.Prefixlen = prefixlen
+		data := msg.Serialize()
+		for _, attr := range attrs {
+			data = append(data, attr.Serialize()...)
+		}
+		return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: kind}, Data: data}
+	}
+	local := nl.NewRtAttr(syscall.IFA_LOCAL, net.ParseIP("10.0.0.1").To4())
+	peer := nl.NewRtAttr(syscall.IFA_ADDRESS, net.ParseIP("10.0.0.2").To4())
+	tests := []struct {
+		name string
+		msg  syscall.NetlinkMessage
+		want []Event
+	}{
+		{
+			name: "added",
+			msg:  addrMessage(syscall.RTM_NEWADDR, 24, local),
+			want: []Event{{Type: EventAddrAdded, Index: 1, Name: "eth0",
+				Addr: &net.IPNet{IP: net.ParseIP("10.0.0.1").To4(), Mask: net.CIDRMask(24, 32)}}},
+		},
+		{
+			name: "point to point",
+			msg:  addrMessage(syscall.RTM_DELADDR, 32, peer, local),
+			want: []Event{{Type: EventAddrRemoved, Index: 1, Name: "eth0",
+				Addr: &net.IPNet{IP: net.ParseIP("10.0.0.1").To4(), Mask: net.CIDRMask(32, 32)}}},
+		},
+		{
+			name: "peer only",
+			msg:  addrMessage(syscall.RTM_NEWADDR, 32, peer),
+			want: []Event{{Type: EventAddrAdded, Index: 1, Name: "eth0",
+				Addr: &net.IPNet{IP: net.ParseIP("10.0.0.2").To4(), Mask: net.CIDRMask(32, 32)}}},
+		},
+		{
+			name: "no address",
+			msg:  addrMessage(syscall.RTM_NEWADDR, 24),
+		},
+	}
+	for _, test := range tests {
+		w := newTestWatcher(WatchFilter{}, map[int]watchedLink{1: {"eth0", true}})
+		if events := w.parse(test.msg); !reflect.DeepEqual(events, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, events, test.want)
+		}
+	}
+}
+
+func TestWatcherDiffLinks(t *testing.T) {
+	w := newTestWatcher(WatchFilter{}, map[int]watchedLink{
+		1: {"eth0", true},
+		2: {"veth0", false},
+		3: {"gone0", true},
+	})
+	listed := map[int]watchedLink{
+		1: {"eth0", true},
+		2: {"veth1", true},
+		4: {"new0", false},
+	}
+	events := w.diffLinks(listed)
+	if len(events) == 0 || events[len(events)-1].Type != EventResync {
+		t.Fatalf("The events %v do not end with a resync", events)
+	}
+	events = events[:len(events)-1]
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Index != events[j].Index {
+			return events[i].Index < events[j].Index
+		}
+		return events[i].Type < events[j].Type
+	})
+	want := []Event{
+		{Type: EventLinkRenamed, Index: 2, Name: "veth1", OldName: "veth0"},
+		{Type: EventLinkUp, Index: 2, Name: "veth1"},
+		{Type: EventLinkRemoved, Index: 3, Name: "gone0"},
+		{Type: EventLinkAdded, Index: 4, Name: "new0"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v, want %v", events, want)
+	}
+	if !reflect.DeepEqual(w.links, listed) {
+		t.Errorf("got links %v, want %v", w.links, listed)
+	}
+	if _, ok := w.names[3]; ok {
+		t.Errorf("The name of the removed link is still cached")
+	}
+	if events := w.diffLinks(listed); len(events) != 1 {
+		t.Errorf("A second resync replayed %v", events)
+	}
+}
+
+func TestWatcherMatch(t *testing.T) {
+	tests := []struct {
+		filter WatchFilter
+		event  Event
+		want   bool
+	}{
+		{WatchFilter{}, Event{Type: EventLinkAdded, Name: "eth0"}, true},
+		{WatchFilter{Types: []EventType{EventLinkUp}}, Event{Type: EventLinkUp, Name: "eth0"}, true},
+		{WatchFilter{Types: []EventType{EventLinkUp}}, Event{Type: EventLinkDown, Name: "eth0"}, false},
+		{WatchFilter{Types: []EventType{EventLinkUp}}, Event{Type: EventResync}, true},
+		{WatchFilter{Names: []string{"veth*"}}, Event{Type: EventLinkAdded, Name: "veth0"}, true},
+		{WatchFilter{Names: []string{"veth*"}}, Event{Type: EventLinkAdded, Name: "eth0"}, false},
+		{WatchFilter{Names: []string{"veth*"}},
+			Event{Type: EventLinkRenamed, Name: "eth1", OldName: "veth0"}, true},
+		{WatchFilter{Names: []string{"veth*"}}, Event{Type: EventResync}, true},
+		{WatchFilter{Names: []string{"*"}}, Event{Type: EventRouteAdded}, true},
+		{WatchFilter{Names: []string{"eth?"}, Types: []EventType{EventAddrAdded}},
+			Event{Type: EventAddrAdded, Name: "eth0"}, true},
+		{WatchFilter{Names: []string{"eth?"}, Types: []EventType{EventAddrAdded}},
+			Event{Type: EventAddrAdded, Name: "eth10"}, false},
+	}
+	for _, test := range tests {
+		w := newTestWatcher(test.filter, nil)
+		if got := w.match(test.event); got != test.want {
+			t.Errorf("%+v with %v: got %v, want %v", test.filter, test.event, got, test.want)
+		}
+	}
+}
+
+func TestLinkUp(t *testing.T) {
+	tests := []struct {
+		flags uint32
+		want  bool
+	}{
+		{0, false},
+		{syscall.IFF_UP, false},
+		{syscall.IFF_RUNNING, false},
+		{upRunning, true},
+		{upRunning | syscall.IFF_BROADCAST, true},
+	}
+	for _, test := range tests {
+		if got := linkUp(test.flags); got != test.want {
+			t.Errorf("linkUp(%#x) = %v, want %v", test.flags, got, test.want)
+		}
+	}
+}